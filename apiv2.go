@@ -0,0 +1,620 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// v2接口按资源组织路由，不再使用/add、/remove这类动词路径，GET请求不需要请求体。
+// 响应结构与v1相同，但出错时返回对应的HTTP状态码(400/404/409/500等)，创建资源成功返回201。
+// handler大多与v1共用，这里的函数只用于生成v2的swagger文档
+
+// statusCodes v2接口出错时返回对应的HTTP状态码
+func statusCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ctxStatusCodesKey, true)
+	}
+}
+
+// withStatus 成功时返回的状态码，如创建资源返回201
+func withStatus(status int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ctxSuccessStatusKey, status)
+	}
+}
+
+func registerRouterV2(router *gin.Engine) {
+	api := router.Group("/api/v2", rateLimit(), concurrencyLimit(), statusCodes())
+	created := withStatus(http.StatusCreated)
+
+	api.GET("/envs", getHelmEnvsV2)
+	api.GET("/config", getConfigV2)
+	api.GET("/audit", listAuditEventsV2)
+
+	repos := api.Group("/repos")
+	{
+		repos.GET("", listRepositoriesV2)
+		repos.POST("", created, audit("repo-add"), addRepositoryV2)
+		repos.POST("/check", checkNewRepositoryV2)
+		repos.POST("/refresh", audit("repo-update"), refreshRepositoriesV2)
+		repos.GET("/:repo", getRepository)
+		repos.PUT("/:repo", audit("repo-edit"), editRepositoryV2)
+		repos.DELETE("/:repo", audit("repo-remove"), removeRepositoryV2)
+		repos.POST("/:repo/check", checkRepositoryV2)
+	}
+
+	charts := api.Group("/charts")
+	{
+		charts.GET("", searchChartsV2)
+		charts.POST("", created, audit("chart-create"), createChartV2)
+		charts.GET("/:repo/:chart/versions", listChartVersionsV2)
+		charts.GET("/:repo/:chart/versions/:version", getChartVersion)
+		charts.PUT("/:repo/:chart/versions/:version", audit("chart-update"), updateChartVersion)
+		charts.GET("/:repo/:chart/versions/:version/export", exportChartVersion)
+		charts.POST("/:repo/:chart/versions/:version/template", renderChartVersion)
+	}
+
+	uploads := api.Group("/uploads")
+	{
+		uploads.GET("", listUploadedChartsV2)
+		uploads.POST("", created, audit("chart-upload"), uploadChartV2)
+	}
+
+	namespaces := api.Group("/namespaces")
+	{
+		namespaces.GET("", listNamespacesV2)
+		namespaces.POST("", created, audit("namespace-create"), createNamespaceV2)
+		namespaces.DELETE("/:namespace", audit("namespace-delete"), deleteNamespaceV2)
+		namespaces.GET("/:namespace/upgrades", listReleaseUpgradesV2)
+	}
+
+	api.GET("/releases", listAllReleasesV2)
+	api.GET("/upgrades", listAllReleaseUpgradesV2)
+
+	releases := api.Group("/namespaces/:namespace/releases")
+	{
+		releases.GET("", listReleasesV2)
+		releases.GET("/:release", getReleaseV2)
+		releases.POST("/:release", created, audit("install"), lockRelease("install"), installReleaseV2)
+		releases.PUT("/:release", audit("upgrade"), lockRelease("upgrade"), upgradeReleaseV2)
+		releases.DELETE("/:release", audit("uninstall"), lockRelease("uninstall"), uninstallReleaseV2)
+		releases.GET("/:release/values", getReleaseValues)
+		releases.GET("/:release/manifest", getReleaseManifest)
+		releases.GET("/:release/notes", getReleaseNotes)
+		releases.GET("/:release/hooks", getReleaseHooks)
+		releases.GET("/:release/status", getReleaseStatusV2)
+		releases.GET("/:release/revisions", listReleaseRevisions)
+		releases.POST("/:release/revisions/:revision/rollback", audit("rollback"), lockRelease("rollback"), rollbackReleaseV2)
+		releases.POST("/:release/recover", audit("recover"), lockRelease("recover"), recoverReleaseV2)
+	}
+}
+
+// @Summary 	获取helm环境信息
+// @Description 获取helm环境信息
+// @Tags		Env
+// @Success 	200 {object} respBody
+// @Router 		/v2/envs [get]
+func getHelmEnvsV2(c *gin.Context) {
+	getHelmEnvs(c)
+}
+
+// @Summary			查看当前配置
+// @Description 	返回当前生效的配置(密码等已屏蔽)，以及最近一次重新加载的时间和错误
+// @Tags			Config
+// @Success 		200 {object} respBody
+// @Router 			/v2/config [get]
+func getConfigV2(c *gin.Context) {
+	getConfig(c)
+}
+
+// @Summary			查询审计日志
+// @Description 	查询变更操作的审计日志，按时间从新到旧排序
+// @Tags			Audit
+// @Param 			user query string false "操作用户"
+// @Param 			namespace query string false "命名空间"
+// @Param 			release query string false "release名称"
+// @Param 			operation query string false "操作类型，如install、upgrade、rollback、uninstall、repo-add"
+// @Param 			outcome query string false "Enums(success, failure, interrupted)"
+// @Param 			since query string false "开始时间，RFC3339格式"
+// @Param 			until query string false "结束时间，RFC3339格式"
+// @Param 			limit query int false "最多返回条数，默认100"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "查询参数错误"
+// @Failure 		404 {object} respBody "没有配置审计日志文件"
+// @Router 			/v2/audit [get]
+func listAuditEventsV2(c *gin.Context) {
+	listAuditEvents(c)
+}
+
+// @Summary 		获取所有仓库
+// @Description 	列出所有仓库
+// @Tags			Repository
+// @Success 		200 {object} respBody
+// @Router 			/v2/repos [get]
+func listRepositoriesV2(c *gin.Context) {
+	listRepositories(c)
+}
+
+// @Summary			添加仓库
+// @Description 	添加chart仓库或oci registry
+// @Tags			Repository
+// @Param           repoinfo body repoAddOptions true "仓库信息"
+// @Success 		201 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		409 {object} respBody "仓库已存在"
+// @Router 			/v2/repos [post]
+func addRepositoryV2(c *gin.Context) {
+	addRepository(c)
+}
+
+// @Summary			添加前检查仓库
+// @Description 	添加仓库前检查DNS解析、TLS握手、认证、index解析，以及证书有效期
+// @Tags			Repository
+// @Param           repoinfo body repoAddOptions true "仓库信息"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Router 			/v2/repos/check [post]
+func checkNewRepositoryV2(c *gin.Context) {
+	checkNewRepository(c)
+}
+
+// @Summary			刷新仓库index
+// @Description 	更新仓库的index，不指定repos时更新全部仓库，返回每个仓库的更新结果
+// @Tags			Repository
+// @Param 			repos query string false "repo1,repo2,repo3..."
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/repos/refresh [post]
+func refreshRepositoriesV2(c *gin.Context) {
+	updateRepositories(c)
+}
+
+// repositoryDetail 仓库信息及index刷新状态，oci registry没有刷新状态
+type repositoryDetail struct {
+	repositoryElement
+	Refresh *repoRefreshStatus `json:"refresh,omitempty"`
+}
+
+// @Summary 		获取仓库
+// @Description 	获取仓库信息及index刷新状态
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/repos/{repo} [get]
+func getRepository(c *gin.Context) {
+	name := c.Param("repo")
+	re, ok := repositories.get(name)
+	if !ok {
+		respErr(c, notFoundf("no repo named %q found", name))
+		return
+	}
+
+	detail := repositoryDetail{repositoryElement: newRepositoryElement(re)}
+	for _, s := range refresher.list() {
+		if s.Name == name {
+			s := s
+			detail.Refresh = &s
+		}
+	}
+	respOK(c, detail)
+}
+
+// @Summary			修改仓库
+// @Description 	修改已添加仓库的地址、认证信息、TLS选项，只修改传入的字段；修改后会下载index验证，验证失败则不做修改
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Param           repoinfo body repoEditOptions true "需要修改的仓库信息"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/repos/{repo} [put]
+func editRepositoryV2(c *gin.Context) {
+	editRepository(c)
+}
+
+// @Summary			删除仓库
+// @Description 	删除仓库及其index缓存
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/repos/{repo} [delete]
+func removeRepositoryV2(c *gin.Context) {
+	removeRepository(c)
+}
+
+// @Summary			检查仓库
+// @Description 	检查已添加仓库的DNS解析、TLS握手、认证、index解析，以及证书有效期
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/repos/{repo}/check [post]
+func checkRepositoryV2(c *gin.Context) {
+	checkRepositoryByName(c)
+}
+
+// @Summary 		搜索chart
+// @Description 	在仓库中搜索chart，返回分页结果和过滤后结果中各过滤条件取值的chart数量(facets)
+// @Tags			Chart
+// @Param   		keyword query string false "搜索关键字"
+// @Param   		version query string false "chart版本范围"
+// @Param   		versions query bool false "是否列出所有版本"
+// @Param   		repo query string false "仓库名称，repo1,repo2..."
+// @Param   		keywords query string false "Chart.yaml中的keywords，keyword1,keyword2...，需全部包含"
+// @Param   		maintainer query string false "维护者名称或邮箱"
+// @Param   		app_version query string false "appVersion范围，如>=1.0.0, <2.0.0"
+// @Param   		type query string false "Enums(application, library)"
+// @Param   		deprecated query bool false "true只列出已废弃chart；false只列出未废弃chart；不传则都列出"
+// @Param   		page query int false "页码，从1开始"
+// @Param   		page_size query int false "每页数量，不传则返回全部"
+// @Success 		200 {object} respBody
+// @Router 			/v2/charts [get]
+func searchChartsV2(c *gin.Context) {
+	listRepoChartsPage(c)
+}
+
+// @Summary			新建chart
+// @Description 	新建一个chart并上传至仓库
+// @Tags			Chart
+// @Param 			newChart body chartNew true "chart信息"
+// @Success 		201 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/charts [post]
+func createChartV2(c *gin.Context) {
+	createChart(c)
+}
+
+// @Summary 		列出chart的所有版本
+// @Description 	根据仓库index列出chart的所有版本，按semver从新到旧排序
+// @Tags			Chart
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库或chart不存在"
+// @Router 			/v2/charts/{repo}/{chart}/versions [get]
+func listChartVersionsV2(c *gin.Context) {
+	listChartVersions(c)
+}
+
+// @Summary 		获取chart版本详细信息
+// @Description 	获取chart某个版本的readme、values、chart信息
+// @Tags			Chart
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Param 			version path string true "chart版本"
+// @Param   		info query string false "Enums(all, readme, values, chart)"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "info错误"
+// @Router 			/v2/charts/{repo}/{chart}/versions/{version} [get]
+func getChartVersion(c *gin.Context) {
+	respChart(c, c.Param("repo")+"/"+c.Param("chart"), c.Param("version"), c.DefaultQuery("info", "all"))
+}
+
+// @Summary			更新chart版本
+// @Description 	用请求中的内容重新生成chart并上传至仓库，覆盖仓库中的该版本；chart名称和版本以路径为准
+// @Tags			Chart
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Param 			version path string true "chart版本"
+// @Param 			chart body ChartView true "chart信息"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		404 {object} respBody "仓库不存在"
+// @Router 			/v2/charts/{repo}/{chart}/versions/{version} [put]
+func updateChartVersion(c *gin.Context) {
+	var view ChartView
+	if err := c.BindJSON(&view); err != nil {
+		respErr(c, badRequestf("%v", err))
+		return
+	}
+	name, version := c.Param("chart"), c.Param("version")
+	if (view.Chart.Name != "" && view.Chart.Name != name) || (view.Chart.Version != "" && view.Chart.Version != version) {
+		respErr(c, badRequestf("chart %s-%s in body does not match %s-%s in path", view.Chart.Name, view.Chart.Version, name, version))
+		return
+	}
+	view.Chart.Name, view.Chart.Version = name, version
+	pushChartView(c, &chartNew{RepoName: c.Param("repo"), ChartView: &view}, true)
+}
+
+// @Summary			获取chart版本的下载地址
+// @Description 	根据仓库index返回chart某个版本压缩包的下载地址，oci仓库返回chart的oci引用
+// @Tags			Chart
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Param 			version path string true "chart版本"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "仓库或chart版本不存在"
+// @Router 			/v2/charts/{repo}/{chart}/versions/{version}/export [get]
+func exportChartVersion(c *gin.Context) {
+	u, err := chartDownloadURL(c.Param("repo"), c.Param("chart"), c.Param("version"))
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, u)
+}
+
+// @Summary			渲染chart版本
+// @Description 	使用请求中的values渲染chart某个版本，多个文件合并到一个yaml返回
+// @Tags			Chart
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Param 			version path string true "chart版本"
+// @Param 			values body map[string]interface{} false "变量"
+// @Success 		200 {object} respBody
+// @Router 			/v2/charts/{repo}/{chart}/versions/{version}/template [post]
+func renderChartVersion(c *gin.Context) {
+	respTemplate(c, c.Param("repo")+"/"+c.Param("chart"), c.Param("version"))
+}
+
+// @Summary			列出上传的chart
+// @Description 	列出上传目录中的chart压缩包
+// @Tags			Chart
+// @Success 		200 {object} respBody
+// @Router 			/v2/uploads [get]
+func listUploadedChartsV2(c *gin.Context) {
+	listUploadedCharts(c)
+}
+
+// @Summary			上传chart
+// @Description 	multipart/form-data上传chart压缩包(chart字段)，需要校验签名时.tgz.prov文件同样上传
+// @Tags			Chart
+// @Param 			chart formData file true "chart压缩包或.prov文件"
+// @Success 		201 {object} respBody
+// @Failure 		400 {object} respBody "文件错误"
+// @Router 			/v2/uploads [post]
+func uploadChartV2(c *gin.Context) {
+	uploadChart(c)
+}
+
+// @Summary			获取命名空间列表
+// @Description 	列出所有命名空间及其标签和release数量
+// @Tags			Namespace
+// @Param 			has_releases query bool false "true只列出有release的命名空间；false只列出没有release的命名空间；不传则都列出"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "查询参数错误"
+// @Router 			/v2/namespaces [get]
+func listNamespacesV2(c *gin.Context) {
+	listNamespaces(c)
+}
+
+// @Summary			创建命名空间
+// @Description 	创建命名空间，可设置标签、注解，并按config.yaml中的quotaTemplates创建资源配额
+// @Tags			Namespace
+// @Param 			namespace body namespaceOptions true "命名空间信息"
+// @Success 		201 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		409 {object} respBody "命名空间已存在"
+// @Router 			/v2/namespaces [post]
+func createNamespaceV2(c *gin.Context) {
+	createNamespace(c)
+}
+
+// @Summary			删除命名空间
+// @Description 	删除命名空间，命名空间下还有release(任意状态)时拒绝删除；kube-system、kube-public、kube-node-lease、default和helm-proxy自己的命名空间不允许删除
+// @Tags			Namespace
+// @Param 			namespace path string true "k8s的命名空间"
+// @Success 		200 {object} respBody
+// @Failure 		403 {object} respBody "受保护的命名空间"
+// @Failure 		404 {object} respBody "命名空间不存在"
+// @Failure 		409 {object} respBody "命名空间下还有release"
+// @Router 			/v2/namespaces/{namespace} [delete]
+func deleteNamespaceV2(c *gin.Context) {
+	deleteNamespace(c)
+}
+
+// @Summary			查看release可升级版本
+// @Description 	将命名空间下所有release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			repo query string false "只在指定仓库中查找，repo1,repo2..."
+// @Param 			devel query bool false "是否包含预发布版本"
+// @Success 		200 {object} respBody
+// @Router 			/v2/namespaces/{namespace}/upgrades [get]
+func listReleaseUpgradesV2(c *gin.Context) {
+	listReleaseUpgrades(c)
+}
+
+// @Summary			查看所有命名空间release可升级版本
+// @Description 	将所有命名空间下release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级；没有集群范围的权限时逐个查询有权限的命名空间
+// @Tags			Release
+// @Param 			repo query string false "只在指定仓库中查找，repo1,repo2..."
+// @Param 			devel query bool false "是否包含预发布版本"
+// @Success 		200 {object} respBody
+// @Router 			/v2/upgrades [get]
+func listAllReleaseUpgradesV2(c *gin.Context) {
+	listAllReleaseUpgrades(c)
+}
+
+// @Summary			获取所有命名空间的release列表
+// @Description 	获取所有命名空间的release信息列表(helm list -A)，过滤条件使用查询参数
+// @Tags			Release
+// @Param 			options query releaseListOptions false "过滤条件"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "查询参数错误"
+// @Router 			/v2/releases [get]
+func listAllReleasesV2(c *gin.Context) {
+	var options releaseListOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		respErr(c, badRequestf("%v", err))
+		return
+	}
+	options.AllNamespaces = true
+	respReleaseList(c, "", &options)
+}
+
+// @Summary			获取命名空间的release列表
+// @Description 	根据命名空间获取release信息列表(helm list)，过滤条件使用查询参数
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			options query releaseListOptions false "过滤条件"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "查询参数错误"
+// @Router 			/v2/namespaces/{namespace}/releases [get]
+func listReleasesV2(c *gin.Context) {
+	var options releaseListOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		respErr(c, badRequestf("%v", err))
+		return
+	}
+	respReleaseList(c, c.Param("namespace"), &options)
+}
+
+// @Summary			获取release
+// @Description 	获取release信息(helm get all)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release} [get]
+func getReleaseV2(c *gin.Context) {
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), "all")
+}
+
+// @Summary			安装release
+// @Description 	安装chart的实例(helm install)。不传chart时可直接提供chart：multipart/form-data上传chart压缩包(chart字段，安装可选项以json放在options字段)，或在json的chart字段中提供ChartView格式的chart；需要校验签名时只能上传压缩包，并在prov字段上传.prov文件
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Param 			chart query string false "chart名称"
+// @Param 			options body inlineInstallOptions true "安装可选项"
+// @Success 		201 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		409 {object} respBody "release已存在或正被其他请求操作"
+// @Router 			/v2/namespaces/{namespace}/releases/{release} [post]
+func installReleaseV2(c *gin.Context) {
+	installRelease(c)
+}
+
+// @Summary			升级release
+// @Description 	升级release(helm upgrade)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Param 			chart query string true "chart名称"
+// @Param 			options body releaseOptions false "升级可选项"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "参数错误"
+// @Failure 		409 {object} respBody "release正被其他请求操作"
+// @Router 			/v2/namespaces/{namespace}/releases/{release} [put]
+func upgradeReleaseV2(c *gin.Context) {
+	upgradeRelease(c)
+}
+
+// @Summary			卸载release
+// @Description 	卸载chart的实例(helm uninstall)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
+// @Router 			/v2/namespaces/{namespace}/releases/{release} [delete]
+func uninstallReleaseV2(c *gin.Context) {
+	uninstallRelease(c)
+}
+
+// @Summary			获取release的values
+// @Description 	获取release安装时提供的values(helm get values)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/values [get]
+func getReleaseValues(c *gin.Context) {
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), "values")
+}
+
+// @Summary			获取release的manifest
+// @Description 	获取release部署的k8s资源(helm get manifest)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/manifest [get]
+func getReleaseManifest(c *gin.Context) {
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), "manifest")
+}
+
+// @Summary			获取release的notes
+// @Description 	获取release的NOTES.txt(helm get notes)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/notes [get]
+func getReleaseNotes(c *gin.Context) {
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), "notes")
+}
+
+// @Summary			获取release的hooks
+// @Description 	获取release的hooks(helm get hooks)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/hooks [get]
+func getReleaseHooks(c *gin.Context) {
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), "hooks")
+}
+
+// @Summary			查看release状态
+// @Description 	获取release状态信息(helm status)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/status [get]
+func getReleaseStatusV2(c *gin.Context) {
+	getReleaseStatus(c)
+}
+
+// @Summary			查看release历史版本
+// @Description 	获取release历史版本(helm history)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/revisions [get]
+func listReleaseRevisions(c *gin.Context) {
+	listReleaseHistories(c)
+}
+
+// @Summary			release回滚
+// @Description 	回滚release到指定的历史版本(helm rollback)
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Param 			revision path int true "release历史版本号"
+// @Success 		200 {object} respBody
+// @Failure 		400 {object} respBody "版本号错误"
+// @Failure 		409 {object} respBody "release正被其他请求操作"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/revisions/{revision}/rollback [post]
+func rollbackReleaseV2(c *gin.Context) {
+	rollbackRelease(c)
+}
+
+// @Summary			恢复卡住的release
+// @Description 	release长时间处于pending-install/pending-upgrade/pending-rollback状态时，将其标记为failed，rollback为true时再回滚到最近一次成功的版本
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Param 			older_than query string false "只恢复超过该时间未变化的release，默认5m"
+// @Param 			rollback query bool false "标记为failed后是否回滚到最近一次成功的版本"
+// @Success 		200 {object} respBody
+// @Failure 		404 {object} respBody "release不存在"
+// @Failure 		409 {object} respBody "release正被其他请求操作"
+// @Router 			/v2/namespaces/{namespace}/releases/{release}/recover [post]
+func recoverReleaseV2(c *gin.Context) {
+	recoverRelease(c)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// testActionConfig 内存存储的release，可以执行install等操作
+func testActionConfig(t *testing.T, namespace string) *action.Configuration {
+	actionConfig := memoryActionConfig(t, namespace)
+	actionConfig.Capabilities = chartutil.DefaultCapabilities
+	return actionConfig
+}
+
+// withTestActionConfig 接口中使用的action配置替换为内存存储，每个命名空间一个
+func withTestActionConfig(t *testing.T) func() {
+	old := newActionConfig
+	configs := map[string]*action.Configuration{}
+	newActionConfig = func(namespace string, log action.DebugLog) (*action.Configuration, error) {
+		if _, ok := configs[namespace]; !ok {
+			configs[namespace] = testActionConfig(t, namespace)
+		}
+		return configs[namespace], nil
+	}
+	return func() { newActionConfig = old }
+}
+
+func serveV2(router *gin.Engine, method, target string, body interface{}) (*httptest.ResponseRecorder, respBody) {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, bytes.NewReader(b))
+	req.Header.Set("Content-Type", gin.MIMEJSON)
+	router.ServeHTTP(w, req)
+	var resp respBody
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestReleasesV2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer withTestActionConfig(t)()
+	router := gin.New()
+	registerRouterV2(router)
+
+	inline := gin.H{"chart": gin.H{"chart": gin.H{"name": "web", "version": "0.1.0"}}}
+	w, resp := serveV2(router, http.MethodPost, "/api/v2/namespaces/team-a/releases/web", inline)
+	if w.Code != http.StatusCreated || resp.Code != 0 {
+		t.Fatalf("install: %d %s", w.Code, w.Body.String())
+	}
+
+	// 同名release已存在
+	w, resp = serveV2(router, http.MethodPost, "/api/v2/namespaces/team-a/releases/web", inline)
+	if w.Code != http.StatusConflict || resp.Code != 1 || resp.Error == "" {
+		t.Errorf("install an existing release: %d %s", w.Code, w.Body.String())
+	}
+	// 参数错误
+	if w, _ := serveV2(router, http.MethodPost, "/api/v2/namespaces/team-a/releases/api", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("install without a chart: %d %s", w.Code, w.Body.String())
+	}
+
+	if w, _ := serveV2(router, http.MethodGet, "/api/v2/namespaces/team-a/releases/web", nil); w.Code != http.StatusOK {
+		t.Errorf("get release: %d %s", w.Code, w.Body.String())
+	}
+	w, resp = serveV2(router, http.MethodGet, "/api/v2/namespaces/team-a/releases/missing", nil)
+	if w.Code != http.StatusNotFound || resp.Code != 1 {
+		t.Errorf("get a missing release: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpdateChartVersionV2NameMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerRouterV2(router)
+
+	tests := []struct {
+		name, version string
+	}{
+		{"mysql", "1.0.0"},
+		{"nginx", "2.0.0"},
+	}
+	for _, tt := range tests {
+		view := gin.H{"chart": gin.H{"name": tt.name, "version": tt.version}}
+		w, resp := serveV2(router, http.MethodPut, "/api/v2/charts/stable/nginx/versions/1.0.0", view)
+		if w.Code != http.StatusBadRequest || resp.Code != 1 {
+			t.Errorf("body %s-%s: %d %s", tt.name, tt.version, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+
+	defaultAuditLimit          = 100
+	defaultAuditWebhookTimeout = 5 * time.Second
+	auditWebhookQueueSize      = 1000
+
+	// gin.Context中的键
+	ctxErrorKey        = "helm-proxy/error"
+	ctxUserKey         = "helm-proxy/user"
+	ctxAuditChartKey   = "helm-proxy/audit-chart"
+	ctxAuditVersionKey = "helm-proxy/audit-version"
+	ctxAuditRepoKey    = "helm-proxy/audit-repo"
+
+	anonymousUser = "anonymous"
+)
+
+// 审计日志配置
+type auditConfig struct {
+	File           string            `yaml:"file" json:"file"`                     //JSON lines文件，只追加
+	Webhook        string            `yaml:"webhook" json:"webhook"`               //每条记录POST到该地址
+	WebhookHeaders map[string]string `yaml:"webhookHeaders" json:"webhookHeaders"` //如Authorization
+	WebhookTimeout duration          `yaml:"webhookTimeout" json:"webhookTimeout"`
+}
+
+type auditEvent struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user,omitempty"`
+	ClientIP   string    `json:"client_ip"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Operation  string    `json:"operation"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Release    string    `json:"release,omitempty"`
+	Repo       string    `json:"repo,omitempty"`
+	Chart      string    `json:"chart,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	ValuesHash string    `json:"values_hash,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Duration   string    `json:"duration"`
+}
+
+type auditLogger struct {
+	mu      sync.Mutex
+	webhook chan *auditEvent
+}
+
+var auditLog = &auditLogger{}
+
+func auditSettings() *auditConfig {
+	ac := currentConfig().Audit
+	if ac == nil {
+		ac = &auditConfig{}
+	}
+	return ac
+}
+
+// audit 记录变更操作的审计日志
+func audit(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		valuesHash := requestValuesHash(c)
+
+		c.Next()
+
+		duration := time.Since(start)
+		e := &auditEvent{
+			Time:       start,
+			User:       requestUser(c),
+			ClientIP:   clientIP(c),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			Operation:  operation,
+			Namespace:  c.Param("namespace"),
+			Release:    c.Param("release"),
+			Repo:       c.GetString(ctxAuditRepoKey),
+			Chart:      c.GetString(ctxAuditChartKey),
+			Version:    c.GetString(ctxAuditVersionKey),
+			ValuesHash: valuesHash,
+			Outcome:    auditSuccess,
+			Status:     c.Writer.Status(),
+			Duration:   duration.String(),
+		}
+		if e.Chart == "" {
+			e.Chart = c.Query("chart")
+		}
+		if e.Repo == "" {
+			e.Repo = c.Param("repo") + c.Param("reponame")
+		}
+		if msg := c.GetString(ctxErrorKey); msg != "" {
+			e.Outcome = auditFailure
+			e.Error = msg
+		}
+		observeOperation(operation, e.Outcome, duration)
+		auditLog.record(e)
+	}
+}
+
+// setAuditChart 记录操作的chart名称和版本
+func setAuditChart(c *gin.Context, name, version string) {
+	c.Set(ctxAuditChartKey, name)
+	c.Set(ctxAuditVersionKey, version)
+}
+
+func setAuditHelmChart(c *gin.Context, chrt *chart.Chart) {
+	if chrt != nil && chrt.Metadata != nil {
+		setAuditChart(c, chrt.Metadata.Name, chrt.Metadata.Version)
+	}
+}
+
+// setAuditRepo 记录操作的仓库，仓库名称不在路径中(如在请求体中)时由handler设置
+func setAuditRepo(c *gin.Context, name string) {
+	c.Set(ctxAuditRepoKey, name)
+}
+
+// requestUser 请求方身份，只使用客户端证书认证的用户，请求头可以随意设置，不能作为身份；
+// 没有认证时为anonymous@连接的远端地址
+func requestUser(c *gin.Context) string {
+	if user := c.GetString(ctxUserKey); user != "" {
+		return user
+	}
+	return anonymousUser + "@" + remoteHost(c)
+}
+
+// remoteHost 连接的远端地址，不使用X-Forwarded-For等可以伪造的请求头；unix socket连接没有远端地址
+func remoteHost(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		return "unix"
+	}
+	return host
+}
+
+// clientIP 客户端IP，只有连接来自trustedProxies中的代理时才使用X-Forwarded-For；unix socket连接为unix
+func clientIP(c *gin.Context) string {
+	if ip := c.ClientIP(); ip != "" {
+		return ip
+	}
+	return remoteHost(c)
+}
+
+// requestValuesHash json请求中values、set、set_string的sha256，请求体读取后放回
+func requestValuesHash(c *gin.Context) string {
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body.Close()
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return ""
+	}
+
+	var values struct {
+		Values          string   `json:"values"`
+		SetValues       []string `json:"set"`
+		SetStringValues []string `json:"set_string"`
+	}
+	if json.Unmarshal(body, &values) != nil {
+		return ""
+	}
+	if values.Values == "" && len(values.SetValues) == 0 && len(values.SetStringValues) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(values)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (l *auditLogger) record(e *auditEvent) {
+	ac := auditSettings()
+	if ac.File != "" {
+		if err := l.append(ac.File, e); err != nil {
+			glog.Errorf("failed to write audit log: %v", err)
+		}
+	}
+	if ac.Webhook != "" {
+		l.send(e)
+	}
+}
+
+func (l *auditLogger) append(file string, e *auditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// send 异步发送到webhook，队列满时丢弃并记录日志，不阻塞请求
+func (l *auditLogger) send(e *auditEvent) {
+	l.mu.Lock()
+	if l.webhook == nil {
+		l.webhook = make(chan *auditEvent, auditWebhookQueueSize)
+		go l.deliver(l.webhook)
+	}
+	ch := l.webhook
+	l.mu.Unlock()
+
+	select {
+	case ch <- e:
+	default:
+		glog.Warningf("audit webhook queue is full, drop event %s %s", e.Operation, e.Route)
+	}
+}
+
+func (l *auditLogger) deliver(ch chan *auditEvent) {
+	for e := range ch {
+		ac := auditSettings()
+		if ac.Webhook == "" {
+			continue
+		}
+		timeout := time.Duration(ac.WebhookTimeout)
+		if timeout <= 0 {
+			timeout = defaultAuditWebhookTimeout
+		}
+		if err := postAuditEvent(ac, timeout, e); err != nil {
+			glog.Warningf("failed to send audit event to webhook: %v", secrets.mask(err.Error()))
+		}
+	}
+}
+
+func postAuditEvent(ac *auditConfig, timeout time.Duration, e *auditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ac.Webhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", gin.MIMEJSON)
+	for k, v := range ac.WebhookHeaders {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// auditFilter 查询条件，空值表示不过滤
+type auditFilter struct {
+	user, namespace, release, operation, outcome string
+	since, until                                 time.Time
+}
+
+func (f *auditFilter) match(e *auditEvent) bool {
+	return (f.user == "" || f.user == e.User) &&
+		(f.namespace == "" || f.namespace == e.Namespace) &&
+		(f.release == "" || f.release == e.Release) &&
+		(f.operation == "" || f.operation == e.Operation) &&
+		(f.outcome == "" || f.outcome == e.Outcome) &&
+		(f.since.IsZero() || !e.Time.Before(f.since)) &&
+		(f.until.IsZero() || e.Time.Before(f.until))
+}
+
+// @Summary			查询审计日志
+// @Description 	查询变更操作的审计日志，按时间从新到旧排序
+// @Tags			Audit
+// @Param 			user query string false "操作用户"
+// @Param 			namespace query string false "命名空间"
+// @Param 			release query string false "release名称"
+// @Param 			operation query string false "操作类型，如install、upgrade、rollback、uninstall、repo-add"
+// @Param 			outcome query string false "Enums(success, failure)"
+// @Param 			since query string false "开始时间，RFC3339格式"
+// @Param 			until query string false "结束时间，RFC3339格式"
+// @Param 			limit query int false "最多返回条数，默认100"
+// @Success 		200 {object} respBody
+// @Router 			/audit [get]
+func listAuditEvents(c *gin.Context) {
+	file := auditSettings().File
+	if file == "" {
+		respErr(c, notFoundf("audit log file is not configured"))
+		return
+	}
+
+	f := &auditFilter{
+		user:      c.Query("user"),
+		namespace: c.Query("namespace"),
+		release:   c.Query("release"),
+		operation: c.Query("operation"),
+		outcome:   c.Query("outcome"),
+	}
+	var err error
+	if v := c.Query("since"); v != "" {
+		if f.since, err = time.Parse(time.RFC3339, v); err != nil {
+			respErr(c, badRequestf("bad since %q", v))
+			return
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if f.until, err = time.Parse(time.RFC3339, v); err != nil {
+			respErr(c, badRequestf("bad until %q", v))
+			return
+		}
+	}
+	limit := defaultAuditLimit
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			respErr(c, badRequestf("bad limit %q", v))
+			return
+		}
+	}
+
+	events, err := readAuditEvents(file, f, limit)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, events)
+}
+
+// readAuditEvents 读取符合条件的最新limit条记录
+func readAuditEvents(file string, f *auditFilter, limit int) ([]*auditEvent, error) {
+	fd, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return []*auditEvent{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var matched []*auditEvent
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := &auditEvent{}
+		if json.Unmarshal(scanner.Bytes(), e) != nil {
+			continue
+		}
+		if f.match(e) {
+			matched = append(matched, e)
+			if len(matched) > limit {
+				matched = matched[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	events := make([]*auditEvent, 0, len(matched))
+	for i := len(matched) - 1; i >= 0; i-- {
+		events = append(events, matched[i])
+	}
+	return events, nil
+}
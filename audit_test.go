@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withAuditConfig 使用临时的审计日志文件，返回文件路径
+func withAuditConfig(t *testing.T, ac *auditConfig) (string, func()) {
+	dir, err := ioutil.TempDir("", "helm-proxy-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := helmConfig
+	ac.File = filepath.Join(dir, "audit.log")
+	helmConfig = &HelmConfig{Audit: ac}
+	return ac.File, func() {
+		helmConfig = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRequestUser(t *testing.T) {
+	tests := []struct {
+		remoteAddr, user, header, want string
+	}{
+		{"10.0.0.1:51234", "alice", "", "alice"},
+		// 请求头可以伪造，不作为身份
+		{"10.0.0.1:51234", "", "alice", "anonymous@10.0.0.1"},
+		{"[fd00::1]:443", "", "", "anonymous@fd00::1"},
+		{"@", "", "", "anonymous@unix"},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = tt.remoteAddr
+		c.Request.Header.Set("X-Remote-User", tt.header)
+		c.Request.Header.Set("X-Client-ID", tt.header)
+		if tt.user != "" {
+			c.Set(ctxUserKey, tt.user)
+		}
+		if got := requestUser(c); got != tt.want {
+			t.Errorf("requestUser(%s, %q, %q) = %q, want %q", tt.remoteAddr, tt.user, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAuditRepoFromBody(t *testing.T) {
+	file, cleanup := withAuditConfig(t, &auditConfig{})
+	defer cleanup()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	// 仓库名称在请求体中，index下载失败也要记录仓库
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/repos", strings.NewReader(`{"name":"from-body","url":"http://127.0.0.1:1/charts"}`))
+	req.Header.Set("Content-Type", gin.MIMEJSON)
+	req.Header.Set("X-Remote-User", "mallory")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	events, err := readAuditEvents(file, &auditFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Repo != "from-body" || e.Operation != "repo-add" || e.Outcome != auditFailure || e.User != "anonymous@192.0.2.1" {
+		t.Errorf("unexpected audit event %+v", e)
+	}
+}
+
+func TestAuditFileSinkAndFilters(t *testing.T) {
+	file, cleanup := withAuditConfig(t, &auditConfig{})
+	defer cleanup()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []*auditEvent{
+		{Time: start, User: "alice", Namespace: "team-a", Release: "web", Operation: "install", Outcome: auditSuccess},
+		{Time: start.Add(time.Hour), User: "bob", Namespace: "team-a", Release: "web", Operation: "upgrade", Outcome: auditFailure},
+		{Time: start.Add(2 * time.Hour), User: "alice", Namespace: "team-b", Release: "db", Operation: "install", Outcome: auditSuccess},
+		{Time: start.Add(3 * time.Hour), User: "alice", Namespace: "team-a", Release: "web", Operation: "uninstall", Outcome: auditSuccess},
+	}
+	for _, e := range events {
+		auditLog.record(e)
+	}
+	// 无法解析的行被跳过
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+	if st, err := os.Stat(file); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("audit log file mode is %v, %v, want 0600", st.Mode(), err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	tests := []struct {
+		query string
+		want  []string // 操作类型，从新到旧
+	}{
+		{"", []string{"uninstall", "install", "upgrade", "install"}},
+		{"user=alice", []string{"uninstall", "install", "install"}},
+		{"namespace=team-a&release=web", []string{"uninstall", "upgrade", "install"}},
+		{"operation=install", []string{"install", "install"}},
+		{"outcome=failure", []string{"upgrade"}},
+		{"since=2026-01-01T01:00:00Z&until=2026-01-01T03:00:00Z", []string{"install", "upgrade"}},
+		{"limit=2", []string{"uninstall", "install"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/audit?"+tt.query, nil))
+		var got []*auditEvent
+		body := respBody{Data: &got}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%q: %d %s", tt.query, w.Code, w.Body.String())
+		}
+		var ops []string
+		for _, e := range got {
+			ops = append(ops, e.Operation)
+		}
+		if strings.Join(ops, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: got %v, want %v", tt.query, ops, tt.want)
+		}
+	}
+
+	for _, query := range []string{"since=yesterday", "until=1", "limit=0"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/audit?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAuditWebhookSink(t *testing.T) {
+	received := make(chan *auditEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer audit-token" || r.Header.Get("Content-Type") != gin.MIMEJSON {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		e := &auditEvent{}
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	file, cleanup := withAuditConfig(t, &auditConfig{
+		Webhook:        server.URL,
+		WebhookHeaders: map[string]string{"Authorization": "Bearer audit-token"},
+	})
+	defer cleanup()
+
+	auditLog.record(&auditEvent{Time: time.Now(), User: "alice", Operation: "install", Release: "web", Outcome: auditSuccess})
+	select {
+	case e := <-received:
+		if e.User != "alice" || e.Operation != "install" || e.Release != "web" {
+			t.Errorf("webhook received %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook did not receive the audit event")
+	}
+	// 同时写入文件
+	if events, err := readAuditEvents(file, &auditFilter{}, 10); err != nil || len(events) != 1 {
+		t.Errorf("file sink has %d events, %v", len(events), err)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,11 +18,14 @@ import (
 	cm "github.com/chartmuseum/helm-push/pkg/chartmuseum"
 	"github.com/chartmuseum/helm-push/pkg/helm"
 	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/repo"
 	"sigs.k8s.io/yaml"
 )
@@ -54,18 +59,21 @@ func findReadme(files []*chart.File) (file *chart.File) {
 func showChart(c *gin.Context) {
 	name := c.Query("chart")
 	if name == "" {
-		respErr(c, fmt.Errorf("chart name can not be empty"))
+		respErr(c, badRequestf("chart name can not be empty"))
 		return
 	}
 	// local charts with abs path *.tgz
 	splitChart := strings.Split(name, ".")
 	if splitChart[len(splitChart)-1] == "tgz" {
-		name = helmConfig.UploadPath + "/" + name
+		name = currentConfig().UploadPath + "/" + name
 	}
 
 	info := c.DefaultQuery("info", "all") // readme, values, chart
-	version := c.Query("version")
+	respChart(c, name, c.Query("version"), info)
+}
 
+// respChart 按info返回chart的全部或部分信息，v1和v2接口共用
+func respChart(c *gin.Context, name, version, info string) {
 	client := action.NewShow(action.ShowAll)
 	client.Version = version
 	all := &ChartView{}
@@ -80,11 +88,11 @@ func showChart(c *gin.Context) {
 	} else if strings.EqualFold(info, "temp") {
 		client.OutputFormat = "template"
 	} else {
-		respErr(c, fmt.Errorf("bad info %s, chart info only support readme/values/chart", info))
+		respErr(c, badRequestf("bad info %s, chart info only support readme/values/chart", info))
 		return
 	}
 
-	cp, err := client.ChartPathOptions.LocateChart(name, settings)
+	cp, err := locateChart(&client.ChartPathOptions, name)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -166,6 +174,42 @@ type ChartView struct {
 	Template []*file                `json:"template"`
 }
 
+// helmChart 将ChartView转换为helm的chart，模板文件名不在templates目录下时自动加上
+func (v *ChartView) helmChart() (*chart.Chart, error) {
+	metadata := v.Chart
+	if metadata.APIVersion == "" {
+		metadata.APIVersion = chart.APIVersionV2
+	}
+	values := v.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	chrt := &chart.Chart{
+		Metadata: &metadata,
+		Values:   values,
+		Raw:      []*chart.File{{Name: chartutil.ValuesfileName, Data: data}},
+	}
+	for _, t := range v.Template {
+		name := t.Name
+		if !strings.HasPrefix(name, "templates/") {
+			name = path.Join("templates", name)
+		}
+		chrt.Templates = append(chrt.Templates, &chart.File{Name: name, Data: []byte(t.Data)})
+	}
+	if v.Readme != "" {
+		chrt.Files = append(chrt.Files, &chart.File{Name: "README.md", Data: []byte(v.Readme)})
+	}
+	if err := chrt.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid chart")
+	}
+	return chrt, nil
+}
+
 // @Summary			显示chart解析后的k8s部署yaml
 // @Description 	显示chart的k8s部署yaml，如果多个文件则合并到一个yaml一起展示出来
 // @Tags			Chart
@@ -174,19 +218,24 @@ type ChartView struct {
 // @Success 		200 {object} respBody
 // @Router 			/charts/template [post]
 func showTemplate(c *gin.Context) {
-	chart := c.Query("chart")
+	respTemplate(c, c.Query("chart"), "")
+}
+
+// respTemplate 使用请求中的values渲染chart，version为空时使用最新版本
+func respTemplate(c *gin.Context, chart, version string) {
 	// var showFiles []string
 	// var compose string
 
 	var vals map[string]interface{}
 	err := c.ShouldBindJSON(&vals)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		respErr(c, err)
 		return
 	}
 
-	actionConfig, err := actionConfigInit(settings.Namespace())
+	actionConfig, err := actionConfigInit(c, settings.Namespace())
 	client := action.NewInstall(actionConfig)
+	client.Version = version
 	client.DryRun = true
 	client.ReleaseName = "RELEASE-NAME"
 	client.Replace = true // Skip the name check
@@ -197,6 +246,7 @@ func showTemplate(c *gin.Context) {
 	rel, err := runInstall(chart, client, vals)
 	if err != nil {
 		respErr(c, err)
+		return
 	}
 	respOK(c, rel.Manifest)
 }
@@ -222,7 +272,7 @@ func writeToFile(outputDir string, name string, data string, append bool) error
 		return err
 	}
 
-	fmt.Printf("wrote %s\n", outfileName)
+	glog.Infof("wrote %s", outfileName)
 	return nil
 }
 
@@ -262,7 +312,7 @@ func exportChart(c *gin.Context) {
 	// c.File(url)
 	var factor downFactor
 	if c.Bind(&factor) != nil {
-		respErr(c, errors.Errorf("missing parameters"))
+		respErr(c, badRequestf("missing parameters"))
 		return
 	}
 	bol, _ := regexp.MatchString(`^([hH][tT]{2}[pP]:\/\/|[hH][tT]{2}[pP][sS]:\/\/|www\.)(([A-Za-z0-9-~]+)\.)+([A-Za-z0-9-~\.\/])+(.tgz)$`, factor.ChartURL)
@@ -277,6 +327,30 @@ func exportChart(c *gin.Context) {
 	return
 }
 
+// chartDownloadURL 仓库index中chart版本的下载地址，oci仓库返回chart的oci引用
+func chartDownloadURL(repoName, name, version string) (string, error) {
+	rc, ok := repositories.get(repoName)
+	if !ok {
+		return "", notFoundf("no repo named %q found", repoName)
+	}
+	if isOCIReference(rc.URL) {
+		ref, err := parseOCIReference(strings.TrimSuffix(rc.URL, "/")+"/"+name, version)
+		if err != nil {
+			return "", badRequestf("%v", err)
+		}
+		return ociScheme + ref.String(), nil
+	}
+	ind, ok := searchCache.indexFile(repoName)
+	if !ok {
+		return "", errors.Errorf("repo %q is corrupt or missing, try to update it", repoName)
+	}
+	cv, err := ind.Get(name, version)
+	if err != nil || len(cv.URLs) == 0 {
+		return "", notFoundf("chart %s version %s not found in repo %s", name, version, repoName)
+	}
+	return repo.ResolveReferenceURL(rc.URL, cv.URLs[0])
+}
+
 type downFactor struct {
 	RepoURL  string `json:"repoUrl"`
 	ChartURL string `json:"chartUrl"`
@@ -294,8 +368,37 @@ func createChart(c *gin.Context) {
 		respErr(c, err)
 		return
 	}
+	pushChartView(c, &chartObj, false)
+}
+
+type chartNew struct {
+	RepoName string `json:"repoName"`
+	*ChartView
+}
+
+// @Summary			更新chart
+// @Description 	用请求中的内容重新生成chart并上传至镜像库，覆盖仓库中同名同版本的chart
+// @Tags			Chart
+// @Param 			chart body chartNew true "chart信息"
+// @Success 		200 {object} respBody
+// @Router 			/charts/update [put]
+func updateChart(c *gin.Context) {
+	var chartObj chartNew
+	if err := c.BindJSON(&chartObj); err != nil {
+		respErr(c, err)
+		return
+	}
+	pushChartView(c, &chartObj, true)
+}
 
-	path, err := ioutil.TempDir(helmConfig.SnapPath, chartObj.Chart.Name+"."+strconv.FormatInt(time.Now().UnixNano(), 10))
+// pushChartView 生成chart并上传至仓库，force为true时覆盖同名同版本的chart
+func pushChartView(c *gin.Context, chartObj *chartNew, force bool) {
+	if chartObj.ChartView == nil || chartObj.Chart.Name == "" {
+		respErr(c, badRequestf("chart name can not be empty"))
+		return
+	}
+
+	path, err := ioutil.TempDir(currentConfig().SnapPath, chartObj.Chart.Name+"."+strconv.FormatInt(time.Now().UnixNano(), 10))
 	if err == nil {
 		//创建Chart.yaml
 		if f, err := os.Create(path + "/Chart.yaml"); err == nil {
@@ -311,7 +414,7 @@ func createChart(c *gin.Context) {
 		if f, err := os.Create(path + "/README.md"); err == nil {
 			f.WriteString(chartObj.Readme)
 		}
-		if err := os.MkdirAll(path+"/templates", 0755); err == nil {
+		if err := os.MkdirAll(path+"/templates", 0755); err == nil && len(chartObj.Template) > 0 {
 			//创建k8s-compose.yaml
 			if f, err := os.Create(path + "/templates/k8s-compose.yaml"); err == nil {
 				f.WriteString(chartObj.Template[0].Data)
@@ -320,37 +423,28 @@ func createChart(c *gin.Context) {
 	}
 	defer os.RemoveAll(path) //销毁临时模板文件夹
 
+	setAuditChart(c, chartObj.Chart.Name, chartObj.Chart.Version)
+	setAuditRepo(c, chartObj.RepoName)
+
 	// 确定repo对象
-	repo := &repo.Entry{}
-	for _, r := range helmConfig.HelmRepos {
-		if r.Name == chartObj.RepoName {
-			repo = r
-			break
-		}
+	repo, ok := repositories.get(chartObj.RepoName)
+	if !ok {
+		respErr(c, notFoundf("no repo named %q found", chartObj.RepoName))
+		return
 	}
 
 	pusher := &pusher{
 		chartName:    path,
 		chartVersion: chartObj.Chart.Version,
 		repoName:     chartObj.RepoName,
+		forceUpload:  force,
 	}
 
-	if err := push(pusher, repo); err != nil {
+	if err := push(pusher, &repo.Entry); err != nil {
 		respErr(c, err)
 	} else {
 		respOK(c, "ok")
 	}
-	return
-}
-
-type chartNew struct {
-	RepoName string `json:"repoName"`
-	*ChartView
-}
-
-//
-func updateChart(c *gin.Context) {
-
 }
 
 // region:上传chart库共通
@@ -392,6 +486,24 @@ func push(p *pusher, r *repo.Entry) error {
 		chart.SetVersion(p.chartVersion)
 	}
 
+	// OCI镜像仓库
+	if isOCIReference(r.URL) {
+		tmp, err := ioutil.TempDir("", "helm-push-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+
+		chartPackagePath, err := helm.CreateChartPackage(chart, tmp)
+		if err != nil {
+			return err
+		}
+		if provenanceSettings().Sign {
+			glog.Warningf("signing is not supported for oci registry, %s is pushed without provenance", filepath.Base(chartPackagePath))
+		}
+		return pushOCIChart(chartPackagePath, r)
+	}
+
 	// username/password override(s)
 	username := r.Username
 	password := r.Password
@@ -440,14 +552,24 @@ func push(p *pusher, r *repo.Entry) error {
 	if err != nil {
 		return err
 	}
+	provPath, err := signChartPackage(chartPackagePath)
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Pushing %s to %s...\n", filepath.Base(chartPackagePath), p.repoName)
+	glog.Infof("pushing %s to %s", filepath.Base(chartPackagePath), p.repoName)
 	resp, err := client.UploadChartPackage(chartPackagePath, p.forceUpload)
 	if err != nil {
 		return err
 	}
+	if err := handlePushResponse(resp); err != nil {
+		return err
+	}
 
-	return handlePushResponse(resp)
+	if provPath != "" {
+		return uploadProvenanceFile(client, r, p, url, provPath)
+	}
+	return nil
 }
 
 func getIndexDownloader(client *cm.Client) helm.IndexDownloader {
@@ -476,7 +598,6 @@ func handlePushResponse(resp *http.Response) error {
 		}
 		return getChartmuseumError(b, resp.StatusCode)
 	}
-	fmt.Println("Done.")
 	return nil
 }
 
@@ -492,3 +613,84 @@ func getChartmuseumError(b []byte, code int) error {
 }
 
 // endregion
+
+// locateChart 查找chart的本地路径，oci://开头的chart从OCI镜像仓库拉取
+func locateChart(opts *action.ChartPathOptions, name string) (string, error) {
+	if err := applyProvenance(opts, name); err != nil {
+		return "", err
+	}
+	if isOCIReference(name) {
+		return pullOCIChart(name, opts.Version)
+	}
+	if cp, ok, err := downloadCrossHostChart(opts, name); ok {
+		return cp, err
+	}
+	return opts.LocateChart(name, settings)
+}
+
+// downloadCrossHostChart helm在下载chart时总会带上仓库的认证信息，
+// 仓库未开启pass_credentials_all且chart地址与仓库不在同一域名时，不带认证信息下载，ok为false表示交给helm处理
+func downloadCrossHostChart(opts *action.ChartPathOptions, name string) (string, bool, error) {
+	if opts.RepoURL != "" || strings.Count(name, "/") != 1 {
+		return "", false, nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		return "", false, nil
+	}
+	repoName, chartName := path.Split(name)
+	rc, ok := repositories.get(strings.TrimSuffix(repoName, "/"))
+	if !ok || rc.PassCredentialsAll || (rc.Username == "" && rc.Password == "") {
+		return "", false, nil
+	}
+	ind, ok := searchCache.indexFile(rc.Name)
+	if !ok {
+		return "", false, nil
+	}
+	cv, err := ind.Get(chartName, opts.Version)
+	if err != nil || len(cv.URLs) == 0 {
+		return "", false, nil
+	}
+	chartURL, err := repo.ResolveReferenceURL(rc.URL, cv.URLs[0])
+	if err != nil {
+		return "", false, nil
+	}
+	u, err := url.Parse(chartURL)
+	if err != nil || u.Host == hostOf(rc.URL) {
+		return "", false, nil
+	}
+
+	g, err := getter.All(settings).ByScheme(u.Scheme)
+	if err != nil {
+		return "", true, err
+	}
+	options := []getter.Option{
+		getter.WithURL(rc.URL),
+		getter.WithTLSClientConfig(rc.CertFile, rc.KeyFile, rc.CAFile),
+		getter.WithInsecureSkipVerifyTLS(rc.InsecureSkipTLSverify),
+	}
+	data, err := g.Get(chartURL, options...)
+	if err != nil {
+		return "", true, err
+	}
+	if err := os.MkdirAll(settings.RepositoryCache, 0755); err != nil {
+		return "", true, err
+	}
+	dest := filepath.Join(settings.RepositoryCache, path.Base(u.Path))
+	if err := ioutil.WriteFile(dest, data.Bytes(), 0644); err != nil {
+		return "", true, err
+	}
+
+	if opts.Verify {
+		body, err := g.Get(chartURL+".prov", options...)
+		if err != nil {
+			return "", true, err
+		}
+		if err := ioutil.WriteFile(dest+".prov", body.Bytes(), 0644); err != nil {
+			return "", true, err
+		}
+		if _, err := downloader.VerifyChart(dest, opts.Keyring); err != nil {
+			return "", true, err
+		}
+	}
+	return dest, true, nil
+}
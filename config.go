@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultConfigWatchInterval = 10 * time.Second
+
+var (
+	configMu   sync.RWMutex
+	helmConfig = &HelmConfig{}
+
+	configReload = &configReloader{}
+)
+
+// currentConfig 当前生效的配置，重新加载时整体替换，不要修改返回的内容
+func currentConfig() *HelmConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return helmConfig
+}
+
+// configErrors 配置中的所有问题，一次全部报告
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return "invalid config:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// loadConfig 读取配置文件并应用HELM_PROXY_*环境变量，校验并解析认证信息，不影响当前生效的配置
+func loadConfig(file string) (*HelmConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	overridden, problems, unknown := applyEnvOverrides(raw, os.Environ())
+	for _, u := range unknown {
+		glog.Warningf("ignored environment variable %s", u)
+	}
+	b, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	conf := &HelmConfig{}
+	if err := yaml.Unmarshal(b, conf); err != nil {
+		return nil, configErrors(append(problems, err.Error()))
+	}
+	for _, r := range conf.HelmRepos {
+		r.envKeys = overridden[r.Name]
+	}
+
+	conf.setDefaults()
+	problems = append(problems, conf.validate()...)
+
+	if err := conf.provenance().resolveCredentials(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, r := range conf.Registries {
+		if err := r.resolveCredentials(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, r := range conf.HelmRepos {
+		if err := r.resolveCredentials(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return nil, configErrors(problems)
+	}
+	return conf, nil
+}
+
+func (hc *HelmConfig) setDefaults() {
+	if hc.UploadPath == "" {
+		hc.UploadPath = defaultUploadPath
+	}
+	if hc.TemplatePath == "" {
+		hc.TemplatePath = defaultTemplatePath
+	}
+	if hc.SnapPath == "" {
+		hc.SnapPath = defaultSnapPath
+	}
+}
+
+// validate 返回配置中的所有问题
+func (hc *HelmConfig) validate() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	fileExists := func(name, file string) {
+		if file == "" {
+			return
+		}
+		if _, err := os.Stat(file); err != nil {
+			addf("%s: %v", name, err)
+		}
+	}
+
+	paths := []struct {
+		name, value, def string
+	}{
+		{"uploadPath", hc.UploadPath, defaultUploadPath},
+		{"templatePath", hc.TemplatePath, defaultTemplatePath},
+		{"snapPath", hc.SnapPath, defaultSnapPath},
+	}
+	for _, p := range paths {
+		if p.value != p.def && !filepath.IsAbs(p.value) {
+			addf("%s %q is not absolute", p.name, p.value)
+		}
+	}
+
+	repoNames := map[string]bool{}
+	for i, r := range hc.HelmRepos {
+		name := fmt.Sprintf("helmRepos[%d]", i)
+		if r.Name == "" {
+			addf("%s: name is required", name)
+		} else {
+			name = "helmRepos." + r.Name
+			if repoNames[r.Name] {
+				addf("repository name (%s) is duplicated in config", r.Name)
+			}
+			repoNames[r.Name] = true
+		}
+		if u, err := url.Parse(r.URL); r.URL == "" || err != nil || (u.Scheme == "" && !isOCIReference(r.URL)) {
+			addf("%s: bad url %q", name, secrets.mask(r.URL))
+		}
+		fileExists(name+".caFile", r.CAFile)
+		fileExists(name+".certFile", r.CertFile)
+		fileExists(name+".keyFile", r.KeyFile)
+		fileExists(name+".keyring", r.Keyring)
+	}
+
+	hosts := map[string]bool{}
+	for i, r := range hc.Registries {
+		name := fmt.Sprintf("registries[%d]", i)
+		if r.Host == "" {
+			addf("%s: host is required", name)
+		} else if hosts[r.Host] {
+			addf("registry host (%s) is duplicated in config", r.Host)
+		}
+		hosts[r.Host] = true
+		fileExists(name+".caFile", r.CAFile)
+		fileExists(name+".certFile", r.CertFile)
+		fileExists(name+".keyFile", r.KeyFile)
+	}
+
+	if rc := hc.RepoRefresh; rc != nil {
+		if rc.Jitter < 0 || rc.Jitter > 1 {
+			addf("repoRefresh.jitter %v is not in [0, 1]", rc.Jitter)
+		}
+		if rc.Interval < 0 || rc.Backoff < 0 || rc.MaxBackoff < 0 {
+			addf("repoRefresh: durations must not be negative")
+		}
+	}
+
+	if pc := hc.Provenance; pc != nil {
+		fileExists("provenance.keyring", pc.Keyring)
+		if pc.Sign {
+			if pc.Key == "" {
+				addf("provenance.key is required when sign is true")
+			}
+			if pc.SigningKeyring == "" {
+				addf("provenance.signingKeyring is required when sign is true")
+			}
+			fileExists("provenance.signingKeyring", pc.SigningKeyring)
+		}
+	}
+
+	if lc := hc.ReleaseLock; lc != nil && lc.LeaseDuration < 0 {
+		addf("releaseLock.leaseDuration must not be negative")
+	}
+	if sc := hc.Shutdown; sc != nil && sc.DrainTimeout < 0 {
+		addf("shutdown.drainTimeout must not be negative")
+	}
+	if lc := hc.RateLimit; lc != nil {
+		if lc.Rate < 0 || lc.Burst < 0 || lc.MaxConcurrent < 0 || lc.MaxConcurrentPerNamespace < 0 || lc.RetryAfter < 0 {
+			addf("rateLimit: values must not be negative")
+		}
+		for identity, r := range lc.Identities {
+			if r == nil || r.Rate < 0 || r.Burst < 0 {
+				addf("rateLimit.identities.%s: rate and burst must not be negative", identity)
+			}
+		}
+	}
+
+	if ac := hc.Audit; ac != nil && ac.Webhook != "" {
+		if u, err := url.Parse(ac.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			addf("audit.webhook %q is not a http(s) url", ac.Webhook)
+		}
+	}
+
+	for _, p := range hc.TrustedProxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				addf("trustedProxies: %q is not an ip or cidr", p)
+			}
+		}
+	}
+
+	if tc := hc.TLS; tc != nil {
+		if tc.CertFile == "" || tc.KeyFile == "" {
+			addf("tls.certFile and tls.keyFile are required")
+		}
+		fileExists("tls.certFile", tc.CertFile)
+		fileExists("tls.keyFile", tc.KeyFile)
+		fileExists("tls.clientCAFile", tc.ClientCAFile)
+		if tc.RequireClientCert && tc.ClientCAFile == "" {
+			addf("tls.clientCAFile is required when requireClientCert is true")
+		}
+	}
+
+	quotaNames := make([]string, 0, len(hc.QuotaTemplates))
+	for name := range hc.QuotaTemplates {
+		quotaNames = append(quotaNames, name)
+	}
+	sort.Strings(quotaNames)
+	for _, name := range quotaNames {
+		for resourceName, value := range hc.QuotaTemplates[name].Hard {
+			if _, err := resource.ParseQuantity(value); err != nil {
+				addf("quotaTemplates.%s.hard.%s: bad quantity %q", name, resourceName, value)
+			}
+		}
+	}
+	return problems
+}
+
+func (hc *HelmConfig) provenance() *provenanceConfig {
+	if hc.Provenance == nil {
+		return &provenanceConfig{}
+	}
+	return hc.Provenance
+}
+
+// secretValues 配置中需要在日志和接口返回中屏蔽的值：密码、webhook请求头和签名私钥的密码，不包括用户名
+func (hc *HelmConfig) secretValues() []string {
+	values := []string{hc.provenance().passphrase}
+	for _, r := range hc.Registries {
+		values = append(values, r.Password)
+	}
+	for _, r := range hc.HelmRepos {
+		values = append(values, r.Password)
+	}
+	if hc.Audit != nil {
+		for _, v := range hc.Audit.WebhookHeaders {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ensureDirs 创建上传目录和临时目录
+func (hc *HelmConfig) ensureDirs() error {
+	for _, dir := range []string{hc.UploadPath, hc.SnapPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConfig 下载新增或有变化仓库的index，全部成功后才替换当前配置和仓库列表
+func applyConfig(conf *HelmConfig) error {
+	// 下载index等出错时也要屏蔽新配置中的认证信息
+	secrets.add(conf.secretValues()...)
+	if err := conf.ensureDirs(); err != nil {
+		return err
+	}
+	old := currentConfig()
+	if certs != nil {
+		if conf.TLS == nil {
+			return errors.New("tls can not be disabled without restart")
+		}
+		if _, err := newCertReloader(conf.TLS); err != nil {
+			return err
+		}
+	} else if conf.TLS != nil {
+		return errors.New("tls can not be enabled without restart")
+	}
+	if conf.UnixSocket != old.UnixSocket && old.UnixSocket != "" {
+		glog.Warningf("unixSocket is changed to %q, it takes effect after restart", conf.UnixSocket)
+	}
+	// 首次加载时old是空配置，UploadPath为空
+	if !reflect.DeepEqual(conf.TrustedProxies, old.TrustedProxies) && old.UploadPath != "" {
+		glog.Warningf("trustedProxies is changed to %v, it takes effect after restart", conf.TrustedProxies)
+	}
+
+	current := map[string]*repoConfig{}
+	for _, r := range repositories.all() {
+		current[r.Name] = r
+	}
+	var downloaded []string
+	for _, r := range conf.HelmRepos {
+		if c, ok := current[r.Name]; ok && reflect.DeepEqual(c.Entry, r.Entry) {
+			continue
+		}
+		if err := initRepository(&r.Entry); err != nil {
+			return errors.Wrapf(err, "failed to download index of repository %s", r.Name)
+		}
+		downloaded = append(downloaded, r.Name)
+	}
+
+	// 从config.yaml中删除的仓库，也从repositories.yaml中删除
+	removed := map[string]bool{}
+	for _, r := range old.HelmRepos {
+		removed[r.Name] = true
+	}
+	for _, r := range conf.HelmRepos {
+		delete(removed, r.Name)
+	}
+	// 仓库列表和配置一起替换，任何一步失败都保留原来的仓库列表和配置
+	err := repositories.load(conf.HelmRepos, removed, func() {
+		configMu.Lock()
+		helmConfig = conf
+		configMu.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+	// 屏蔽列表只保留仍在使用的认证信息，包括通过接口添加、只保存在repositories.yaml中的仓库
+	values := append(conf.secretValues(), settings.KubeToken)
+	for _, r := range repositories.all() {
+		values = append(values, r.Password)
+	}
+	secrets.reset(values...)
+
+	if certs != nil {
+		certs.update(conf.TLS)
+	}
+	for _, name := range downloaded {
+		refresher.record(name, indexChartCount(name), nil)
+	}
+	searchCache.invalidate()
+	return nil
+}
+
+// configReloader 配置文件变化或收到SIGHUP时重新加载，校验失败则保留原配置
+type configReloader struct {
+	mu      sync.Mutex
+	modTime time.Time
+	state   configReloadStatus
+}
+
+type configReloadStatus struct {
+	LoadedAt   time.Time  `json:"loaded_at"`             //当前配置的加载时间
+	LastReload *time.Time `json:"last_reload,omitempty"` //最近一次尝试重新加载的时间
+	LastError  string     `json:"last_error,omitempty"`  //最近一次重新加载失败的原因
+}
+
+func (r *configReloader) loaded(file string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st, err := os.Stat(file); err == nil {
+		r.modTime = st.ModTime()
+	}
+	r.state.LoadedAt = time.Now()
+}
+
+func (r *configReloader) reload(file, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if st, err := os.Stat(file); err == nil {
+		r.modTime = st.ModTime()
+	}
+	now := time.Now()
+	r.state.LastReload = &now
+	conf, err := loadConfig(file)
+	if err == nil {
+		err = applyConfig(conf)
+	}
+	if err != nil {
+		r.state.LastError = secrets.mask(err.Error())
+		glog.Errorf("reload config (%s) failed, keep using the old one: %s", reason, r.state.LastError)
+		return err
+	}
+	r.state.LastError = ""
+	r.state.LoadedAt = now
+	glog.Infof("config reloaded (%s)", reason)
+	return nil
+}
+
+// watch 定时检查配置文件的修改时间
+func (r *configReloader) watch(file string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			st, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			r.mu.Lock()
+			changed := !st.ModTime().Equal(r.modTime)
+			r.mu.Unlock()
+			if changed {
+				r.reload(file, "file changed")
+			}
+		}
+	}
+}
+
+func (r *configReloader) status() configReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// redacted 屏蔽密码等认证信息，仓库列表使用当前生效的(包含通过api添加的)
+func (hc *HelmConfig) redacted() *HelmConfig {
+	r := *hc
+	r.HelmRepos = nil
+	for _, e := range repositories.all() {
+		p := *e
+		p.Password = redact(p.Password)
+		r.HelmRepos = append(r.HelmRepos, &p)
+	}
+	r.Registries = nil
+	for _, e := range hc.Registries {
+		p := *e
+		p.Password = redact(p.Password)
+		r.Registries = append(r.Registries, &p)
+	}
+	if hc.Audit != nil {
+		a := *hc.Audit
+		a.WebhookHeaders = map[string]string{}
+		for k, v := range hc.Audit.WebhookHeaders {
+			a.WebhookHeaders[k] = redact(v)
+		}
+		r.Audit = &a
+	}
+	return &r
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return maskedValue
+}
+
+// @Summary			查看当前配置
+// @Description 	返回当前生效的配置(密码等已屏蔽)，以及最近一次重新加载的时间和错误
+// @Tags			Config
+// @Success 		200 {object} respBody
+// @Router 			/config [get]
+func getConfig(c *gin.Context) {
+	b, err := json.Marshal(currentConfig().redacted())
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, gin.H{
+		"file":   configFile,
+		"config": json.RawMessage(secrets.mask(string(b))),
+		"reload": configReload.status(),
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// setupApplyConfig 把helm的仓库配置和缓存放到临时目录，测试结束后恢复配置、仓库列表和屏蔽列表
+func setupApplyConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "helm-proxy-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldConfig, oldRepos, oldSecrets := helmConfig, repositories.repos, secrets.values
+	oldRepoConfig, oldCache, oldConfigFile := settings.RepositoryConfig, settings.RepositoryCache, configFile
+	settings.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	settings.RepositoryCache = filepath.Join(dir, "cache")
+	configFile = ""
+	helmConfig = &HelmConfig{}
+	repositories.repos = nil
+	searchCache.invalidate()
+
+	return dir, func() {
+		helmConfig, repositories.repos, secrets.values = oldConfig, oldRepos, oldSecrets
+		settings.RepositoryConfig, settings.RepositoryCache, configFile = oldRepoConfig, oldCache, oldConfigFile
+		searchCache.invalidate()
+		os.RemoveAll(dir)
+	}
+}
+
+// testConfig 只有一个仓库的配置
+func testConfig(dir, url, username, password string) *HelmConfig {
+	conf := &HelmConfig{
+		UploadPath: filepath.Join(dir, "upload"),
+		SnapPath:   filepath.Join(dir, "snap"),
+		HelmRepos:  []*repoConfig{{Entry: repo.Entry{Name: "stable", URL: url, Username: username, Password: password}}},
+	}
+	conf.setDefaults()
+	return conf
+}
+
+func TestApplyConfigRebuildsSecrets(t *testing.T) {
+	dir, cleanup := setupApplyConfig(t)
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	if err := applyConfig(testConfig(dir, server.URL, "repo-user", "first-password")); err != nil {
+		t.Fatal(err)
+	}
+	if got := secrets.mask("repo-user first-password"); got != "repo-user ******" {
+		t.Errorf("mask: %q, want only the password masked", got)
+	}
+
+	// 修改密码后旧密码不再屏蔽
+	if err := applyConfig(testConfig(dir, server.URL, "repo-user", "second-password")); err != nil {
+		t.Fatal(err)
+	}
+	if got := secrets.mask("repo-user first-password second-password"); got != "repo-user first-password ******" {
+		t.Errorf("mask after password change: %q", got)
+	}
+}
+
+// writeConfigFile 写入config.yaml，修改时间设置为mtime
+func writeConfigFile(t *testing.T, file, content string, mtime time.Time) {
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func repoNames() []string {
+	var names []string
+	for _, r := range repositories.all() {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestConfigReload(t *testing.T) {
+	dir, cleanup := setupApplyConfig(t)
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/missing/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(dir, "config.yaml")
+	paths := fmt.Sprintf("uploadPath: %s\nsnapPath: %s\n", filepath.Join(dir, "upload"), filepath.Join(dir, "snap"))
+	stable := fmt.Sprintf("  - name: stable\n    url: %s/stable\n", server.URL)
+	start := time.Now().Add(-time.Hour)
+	writeConfigFile(t, file, paths+"helmRepos:\n"+stable, start)
+
+	reloader := &configReloader{}
+	if err := reloader.reload(file, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := repoNames(); !reflect.DeepEqual(got, []string{"stable"}) {
+		t.Fatalf("repositories: %v", got)
+	}
+
+	// 配置文件变化后自动重新加载
+	stop := make(chan struct{})
+	go reloader.watch(file, 10*time.Millisecond, stop)
+	writeConfigFile(t, file, paths+"helmRepos:\n"+stable+fmt.Sprintf("  - name: incubator\n    url: %s/incubator\n", server.URL), start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(currentConfig().HelmRepos) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if got := repoNames(); !reflect.DeepEqual(got, []string{"stable", "incubator"}) {
+		t.Fatalf("repositories after the file changed: %v", got)
+	}
+	if st := reloader.status(); st.LastReload == nil || st.LastError != "" {
+		t.Errorf("status after a successful reload: %+v", st)
+	}
+
+	// 无效的配置被拒绝，继续使用原来的配置
+	loaded := currentConfig()
+	invalid := []struct {
+		name, content, want string
+	}{
+		{"bad yaml", "helmRepos: [", "yaml"},
+		{"duplicated repository", paths + "helmRepos:\n" + stable + stable, "duplicated"},
+		{"bad value", paths + "helmRepos:\n" + stable + "repoRefresh:\n  jitter: 2\n", "jitter"},
+		{"index download failure", paths + "helmRepos:\n" + stable + fmt.Sprintf("  - name: broken\n    url: %s/missing\n", server.URL), "broken"},
+	}
+	for _, tt := range invalid {
+		writeConfigFile(t, file, tt.content, start.Add(2*time.Minute))
+		if err := reloader.reload(file, "test"); err == nil {
+			t.Errorf("%s: reload succeeded", tt.name)
+		}
+		if currentConfig() != loaded {
+			t.Errorf("%s: config is replaced", tt.name)
+		}
+		if got := repoNames(); !reflect.DeepEqual(got, []string{"stable", "incubator"}) {
+			t.Errorf("%s: repositories %v", tt.name, got)
+		}
+		if st := reloader.status(); !strings.Contains(st.LastError, tt.want) {
+			t.Errorf("%s: last error %q, want it to contain %q", tt.name, st.LastError, tt.want)
+		}
+	}
+}
+
+func TestGetConfigRedactsSecrets(t *testing.T) {
+	dir, cleanup := setupApplyConfig(t)
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	conf := testConfig(dir, server.URL, "repo-user", "repo-password")
+	conf.Registries = []*registryConfig{{Host: "registry.example.com", Username: "registry-user", Password: "registry-password"}}
+	conf.Audit = &auditConfig{Webhook: "https://audit.example.com", WebhookHeaders: map[string]string{"Authorization": "Bearer webhook-token"}}
+	if err := applyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/config", getConfig)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, secret := range []string{"repo-password", "registry-password", "webhook-token"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("%s is not redacted: %s", secret, body)
+		}
+	}
+	for _, user := range []string{"repo-user", "registry-user", "Authorization"} {
+		if !strings.Contains(body, user) {
+			t.Errorf("%s is missing: %s", user, body)
+		}
+	}
+	if n := strings.Count(body, maskedValue); n != 3 {
+		t.Errorf("%d masked values, want 3: %s", n, body)
+	}
+
+	// 配置中的密码不会被修改
+	if currentConfig().Registries[0].Password != "registry-password" || currentConfig().Audit.WebhookHeaders["Authorization"] != "Bearer webhook-token" {
+		t.Error("redacted modifies the current config")
+	}
+}
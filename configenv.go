@@ -0,0 +1,192 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// 环境变量覆盖配置项，如 HELM_PROXY_UPLOAD_PATH=/data/upload，层级之间用双下划线分隔，
+// 列表元素用下标或name指定，如 HELM_PROXY_HELM_REPOS__STABLE__PASSWORD
+const configEnvPrefix = "HELM_PROXY_"
+
+const unknownConfigKey = "unknown config key "
+
+// applyEnvOverrides 把环境变量写入配置文件解析出的原始结构(不能为nil)，返回被覆盖的仓库字段、
+// 无法应用的环境变量和不是配置项的环境变量(只警告)
+func applyEnvOverrides(raw map[string]interface{}, environ []string) (map[string][]string, []string, []string) {
+	sort.Strings(environ)
+	var problems, unknown []string
+	var repoPaths [][]string
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, configEnvPrefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		name, value := kv[:i], kv[i+1:]
+		if isServiceLinkEnv(name) {
+			continue
+		}
+		segs := strings.Split(strings.TrimPrefix(name, configEnvPrefix), "__")
+		var path []string
+		if _, err := setConfigPath(raw, reflect.TypeOf(HelmConfig{}), segs, value, &path); err != "" {
+			if strings.HasPrefix(err, unknownConfigKey) {
+				unknown = append(unknown, name+": "+err)
+			} else {
+				problems = append(problems, name+": "+err)
+			}
+			continue
+		}
+		if len(path) > 2 && path[0] == "helmRepos" {
+			repoPaths = append(repoPaths, path)
+		}
+	}
+
+	// 仓库按name记录被覆盖的字段，写回config.yaml时保留文件中原来的值
+	overridden := map[string][]string{}
+	repos, _ := raw["helmRepos"].([]interface{})
+	for _, path := range repoPaths {
+		index, _ := strconv.Atoi(path[1])
+		if e, ok := repos[index].(map[string]interface{}); ok {
+			name, _ := e["name"].(string)
+			overridden[name] = append(overridden[name], path[2])
+		}
+	}
+	return overridden, problems, unknown
+}
+
+// isServiceLinkEnv k8s为同命名空间中名为helm-proxy的Service注入的环境变量，
+// 如 HELM_PROXY_SERVICE_HOST、HELM_PROXY_PORT、HELM_PROXY_PORT_18080_TCP_ADDR
+func isServiceLinkEnv(name string) bool {
+	key := strings.TrimPrefix(name, configEnvPrefix)
+	return key == "PORT" || strings.HasPrefix(key, "PORT_") || strings.HasPrefix(key, "SERVICE_")
+}
+
+// setConfigPath 按配置结构的json tag查找segs对应的位置并设置value，返回修改后的node
+func setConfigPath(node interface{}, t reflect.Type, segs []string, value string, path *[]string) (interface{}, string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(segs) == 0 {
+		return envValue(t, value), ""
+	}
+	seg := normalizeConfigKey(segs[0])
+	if seg == "" {
+		return nil, "empty key"
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, _ := node.(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+		key, ft, ok := configField(t, seg)
+		if !ok {
+			return nil, unknownConfigKey + strings.ToLower(segs[0])
+		}
+		*path = append(*path, key)
+		child, err := setConfigPath(m[key], ft, segs[1:], value, path)
+		if err != "" {
+			return nil, err
+		}
+		m[key] = child
+		return m, ""
+
+	case reflect.Map:
+		m, _ := node.(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+		key := strings.ToLower(segs[0])
+		for k := range m {
+			if normalizeConfigKey(k) == seg {
+				key = k
+			}
+		}
+		*path = append(*path, key)
+		child, err := setConfigPath(m[key], t.Elem(), segs[1:], value, path)
+		if err != "" {
+			return nil, err
+		}
+		m[key] = child
+		return m, ""
+
+	case reflect.Slice:
+		list, _ := node.([]interface{})
+		index := -1
+		if n, err := strconv.Atoi(seg); err == nil {
+			if n > len(list) {
+				return nil, "index " + seg + " out of range"
+			}
+			index = n
+		} else {
+			for i, e := range list {
+				if m, ok := e.(map[string]interface{}); ok {
+					if name, _ := m["name"].(string); normalizeConfigKey(name) == seg {
+						index = i
+					}
+				}
+			}
+			if index < 0 {
+				return nil, "no element named " + strings.ToLower(segs[0])
+			}
+		}
+		if index == len(list) {
+			list = append(list, nil)
+		}
+		*path = append(*path, strconv.Itoa(index))
+		child, err := setConfigPath(list[index], t.Elem(), segs[1:], value, path)
+		if err != "" {
+			return nil, err
+		}
+		list[index] = child
+		return list, ""
+	}
+	return nil, unknownConfigKey + strings.ToLower(segs[0])
+}
+
+// configField 查找json tag对应的字段，包括内嵌结构(如repo.Entry)的字段
+func configField(t reflect.Type, seg string) (string, reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if key, ft, ok := configField(f.Type, seg); ok {
+				return key, ft, true
+			}
+			continue
+		}
+		key := strings.Split(f.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" || f.PkgPath != "" {
+			continue
+		}
+		if normalizeConfigKey(key) == seg {
+			return key, f.Type, true
+		}
+	}
+	return "", nil, false
+}
+
+// envValue 字符串类型原样使用，其他类型按yaml解析，如 true、10、[a, b]
+func envValue(t reflect.Type, value string) interface{} {
+	if t.Kind() == reflect.String {
+		return value
+	}
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+		return value
+	}
+	return v
+}
+
+// normalizeConfigKey 忽略大小写和分隔符，uploadPath、UPLOAD_PATH、upload-path视为相同
+func normalizeConfigKey(key string) string {
+	key = strings.ToLower(key)
+	return strings.NewReplacer("_", "", "-", "", ".", "").Replace(key)
+}
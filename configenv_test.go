@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	raw := map[string]interface{}{
+		"helmRepos": []interface{}{map[string]interface{}{"name": "stable", "url": "https://charts.example.com/stable"}},
+	}
+	environ := []string{
+		"HELM_PROXY_UPLOAD_PATH=/data/upload",
+		"HELM_PROXY_HELM_REPOS__STABLE__PASSWORD=secret",
+		// k8s为helm-proxy Service注入的环境变量
+		"HELM_PROXY_SERVICE_HOST=10.0.0.1",
+		"HELM_PROXY_SERVICE_PORT=18080",
+		"HELM_PROXY_SERVICE_PORT_HTTP=18080",
+		"HELM_PROXY_PORT=tcp://10.0.0.1:18080",
+		"HELM_PROXY_PORT_18080_TCP_ADDR=10.0.0.1",
+		"HELM_PROXY_UNKNOWN_OPTION=1",
+		"HELM_PROXY_HELM_REPOS__MISSING__PASSWORD=secret",
+		"PATH=/usr/bin",
+	}
+
+	overridden, problems, unknown := applyEnvOverrides(raw, environ)
+	if raw["uploadPath"] != "/data/upload" {
+		t.Errorf("uploadPath = %v, want /data/upload", raw["uploadPath"])
+	}
+	if repo := raw["helmRepos"].([]interface{})[0].(map[string]interface{}); repo["password"] != "secret" {
+		t.Errorf("password of stable = %v, want secret", repo["password"])
+	}
+	if !reflect.DeepEqual(overridden, map[string][]string{"stable": {"password"}}) {
+		t.Errorf("overridden = %v", overridden)
+	}
+	if want := []string{"HELM_PROXY_HELM_REPOS__MISSING__PASSWORD: no element named missing"}; !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems = %q, want %q", problems, want)
+	}
+	if want := []string{"HELM_PROXY_UNKNOWN_OPTION: unknown config key unknown_option"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown = %q, want %q", unknown, want)
+	}
+}
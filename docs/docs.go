@@ -31,6 +31,73 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/audit": {
+            "get": {
+                "description": "查询变更操作的审计日志，按时间从新到旧排序",
+                "tags": [
+                    "Audit"
+                ],
+                "summary": "查询审计日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "操作用户",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "命名空间",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作类型，如install、upgrade、rollback、uninstall、repo-add",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enums(success, failure)",
+                        "name": "outcome",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间，RFC3339格式",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "结束时间，RFC3339格式",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "最多返回条数，默认100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
         "/charts": {
             "get": {
                 "description": "根据chart名称，获取chart的readme、values、chart、template信息",
@@ -160,6 +227,51 @@ var doc = `{
                 }
             }
         },
+        "/charts/update": {
+            "put": {
+                "description": "用请求中的内容重新生成chart并上传至镜像库，覆盖仓库中同名同版本的chart",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "更新chart",
+                "parameters": [
+                    {
+                        "description": "chart信息",
+                        "name": "chart",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.chartNew"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/config": {
+            "get": {
+                "description": "返回当前生效的配置(密码等已屏蔽)，以及最近一次重新加载的时间和错误",
+                "tags": [
+                    "Config"
+                ],
+                "summary": "查看当前配置",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
         "/envs": {
             "get": {
                 "description": "获取helm环境信息",
@@ -177,6 +289,83 @@ var doc = `{
                 }
             }
         },
+        "/namespaces": {
+            "get": {
+                "description": "列出所有命名空间及其标签和release数量",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "获取命名空间列表",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "true只列出有release的命名空间；false只列出没有release的命名空间；不传则都列出",
+                        "name": "has_releases",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "创建命名空间，可设置标签、注解，并按config.yaml中的quotaTemplates创建资源配额",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "创建命名空间",
+                "parameters": [
+                    {
+                        "description": "命名空间信息",
+                        "name": "namespace",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.namespaceOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/namespaces/{namespace}": {
+            "delete": {
+                "description": "删除命名空间，命名空间下还有release(任意状态)时拒绝删除；kube-system、kube-public、kube-node-lease、default和helm-proxy自己的命名空间不允许删除",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "删除命名空间",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
         "/namespaces/{namespace}/releases": {
             "get": {
                 "description": "根据命名空间获取release信息列表(helm list)",
@@ -276,11 +465,17 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/main.respBody"
                         }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "安装chart的实例(helm install)",
+                "description": "安装chart的实例(helm install)。不传chart时可直接提供chart：multipart/form-data上传chart压缩包(chart字段，安装可选项以json放在options字段)，或在json的chart字段中提供ChartView格式的chart；需要校验签名时只能上传压缩包，并在prov字段上传.prov文件",
                 "tags": [
                     "Release"
                 ],
@@ -304,8 +499,7 @@ var doc = `{
                         "type": "string",
                         "description": "chart名称",
                         "name": "chart",
-                        "in": "query",
-                        "required": true
+                        "in": "query"
                     },
                     {
                         "description": "安装可选项",
@@ -313,7 +507,7 @@ var doc = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.releaseOptions"
+                            "$ref": "#/definitions/main.inlineInstallOptions"
                         }
                     }
                 ],
@@ -323,6 +517,12 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/main.respBody"
                         }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
                     }
                 }
             },
@@ -354,6 +554,12 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/main.respBody"
                         }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
                     }
                 }
             }
@@ -391,6 +597,57 @@ var doc = `{
                 }
             }
         },
+        "/namespaces/{namespace}/releases/{release}/recover": {
+            "post": {
+                "description": "release长时间处于pending-install/pending-upgrade/pending-rollback状态时，将其标记为failed，rollback为true时再回滚到最近一次成功的版本",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "恢复卡住的release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "只恢复超过该时间未变化的release，默认5m",
+                        "name": "older_than",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "标记为failed后是否回滚到最近一次成功的版本",
+                        "name": "rollback",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
         "/namespaces/{namespace}/releases/{release}/status": {
             "get": {
                 "description": "获取release状态信息(helm status)",
@@ -454,6 +711,50 @@ var doc = `{
                         "required": true
                     }
                 ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/namespaces/{namespace}/upgrades": {
+            "get": {
+                "description": "将命名空间下所有release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看release可升级版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "只在指定仓库中查找，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "是否包含预发布版本",
+                        "name": "devel",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -511,7 +812,7 @@ var doc = `{
         },
         "/repos/charts": {
             "get": {
-                "description": "在本地库中查找chart，如没有keyword则列出所有chart",
+                "description": "在本地库中查找chart，如没有keyword则列出所有chart，支持按仓库、关键字、维护者、appVersion、类型、是否废弃过滤",
                 "tags": [
                     "Repository"
                 ],
@@ -534,30 +835,1848 @@ var doc = `{
                         "description": "如果true，查询出每个chart的所有版本；false，只列出每个chart最新版",
                         "name": "versions",
                         "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.respBody"
-                        }
-                    }
-                }
-            }
-        },
-        "/repos/remove/{reponame}": {
-            "delete": {
-                "description": "通过名称，删除一个镜像库",
+                    },
+                    {
+                        "type": "string",
+                        "description": "仓库名称，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Chart.yaml中的keywords，keyword1,keyword2...，需全部包含",
+                        "name": "keywords",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "维护者名称或邮箱",
+                        "name": "maintainer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "appVersion范围，如\u003e=1.0.0, \u003c2.0.0",
+                        "name": "app_version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enums(application, library)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "true只列出已废弃chart；false只列出未废弃chart；不传则都列出",
+                        "name": "deprecated",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/check": {
+            "post": {
+                "description": "添加仓库前检查DNS解析、TLS握手、认证、index解析，以及证书有效期",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "添加前检查chart镜像库",
+                "parameters": [
+                    {
+                        "description": "仓库信息",
+                        "name": "repoinfo",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.repoAddOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/remove/{reponame}": {
+            "delete": {
+                "description": "通过名称，删除一个镜像库",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "删除chart镜像库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "repo1,repo2,repo3...",
+                        "name": "reponame",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/status": {
+            "get": {
+                "description": "列出每个仓库最近一次刷新成功时间、错误信息和chart数量",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "查看仓库index刷新状态",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/update": {
+            "put": {
+                "description": "更新chart仓库的index，不指定repos时更新全部仓库，返回每个仓库的更新结果",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "更新chart镜像库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "repo1,repo2,repo3...",
+                        "name": "repos",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/{repo}": {
+            "put": {
+                "description": "修改已添加仓库的地址、认证信息、TLS选项，只修改传入的字段；修改后会下载index验证，验证失败则不做修改",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "修改chart镜像库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "需要修改的仓库信息",
+                        "name": "repoinfo",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.repoEditOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/{repo}/charts/{chart}/versions": {
+            "get": {
+                "description": "根据仓库index列出chart的所有版本，按semver从新到旧排序",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "列出chart的所有版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/repos/{repo}/check": {
+            "post": {
+                "description": "检查已添加仓库的DNS解析、TLS握手、认证、index解析，以及证书有效期和index新旧",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "检查chart镜像库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/upgrades": {
+            "get": {
+                "description": "将所有命名空间下release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级；没有集群范围的权限时逐个查询有权限的命名空间",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看所有命名空间release可升级版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "只在指定仓库中查找，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "是否包含预发布版本",
+                        "name": "devel",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/audit": {
+            "get": {
+                "description": "查询变更操作的审计日志，按时间从新到旧排序",
+                "tags": [
+                    "Audit"
+                ],
+                "summary": "查询审计日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "操作用户",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "命名空间",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作类型，如install、upgrade、rollback、uninstall、repo-add",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enums(success, failure, interrupted)",
+                        "name": "outcome",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间，RFC3339格式",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "结束时间，RFC3339格式",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "最多返回条数，默认100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "查询参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "没有配置审计日志文件",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/charts": {
+            "get": {
+                "description": "在仓库中搜索chart，返回分页结果和过滤后结果中各过滤条件取值的chart数量(facets)",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "搜索chart",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "搜索关键字",
+                        "name": "keyword",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart版本范围",
+                        "name": "version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "是否列出所有版本",
+                        "name": "versions",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "仓库名称，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Chart.yaml中的keywords，keyword1,keyword2...，需全部包含",
+                        "name": "keywords",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "维护者名称或邮箱",
+                        "name": "maintainer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "appVersion范围，如\u003e=1.0.0, \u003c2.0.0",
+                        "name": "app_version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enums(application, library)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "true只列出已废弃chart；false只列出未废弃chart；不传则都列出",
+                        "name": "deprecated",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "页码，从1开始",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "每页数量，不传则返回全部",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "新建一个chart并上传至仓库",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "新建chart",
+                "parameters": [
+                    {
+                        "description": "chart信息",
+                        "name": "newChart",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.chartNew"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/charts/{repo}/{chart}/versions": {
+            "get": {
+                "description": "根据仓库index列出chart的所有版本，按semver从新到旧排序",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "列出chart的所有版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库或chart不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/charts/{repo}/{chart}/versions/{version}": {
+            "get": {
+                "description": "获取chart某个版本的readme、values、chart信息",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "获取chart版本详细信息",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart版本",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enums(all, readme, values, chart)",
+                        "name": "info",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "info错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "用请求中的内容重新生成chart并上传至仓库，覆盖仓库中的该版本；chart名称和版本以路径为准",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "更新chart版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart版本",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "chart信息",
+                        "name": "chart",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ChartView"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/charts/{repo}/{chart}/versions/{version}/export": {
+            "get": {
+                "description": "根据仓库index返回chart某个版本压缩包的下载地址，oci仓库返回chart的oci引用",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "获取chart版本的下载地址",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart版本",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库或chart版本不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/charts/{repo}/{chart}/versions/{version}/template": {
+            "post": {
+                "description": "使用请求中的values渲染chart某个版本，多个文件合并到一个yaml返回",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "渲染chart版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart版本",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "变量",
+                        "name": "values",
+                        "in": "body",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/config": {
+            "get": {
+                "description": "返回当前生效的配置(密码等已屏蔽)，以及最近一次重新加载的时间和错误",
+                "tags": [
+                    "Config"
+                ],
+                "summary": "查看当前配置",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/envs": {
+            "get": {
+                "description": "获取helm环境信息",
+                "tags": [
+                    "Env"
+                ],
+                "summary": "获取helm环境信息",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces": {
+            "get": {
+                "description": "列出所有命名空间及其标签和release数量",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "获取命名空间列表",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "true只列出有release的命名空间；false只列出没有release的命名空间；不传则都列出",
+                        "name": "has_releases",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "查询参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "创建命名空间，可设置标签、注解，并按config.yaml中的quotaTemplates创建资源配额",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "创建命名空间",
+                "parameters": [
+                    {
+                        "description": "命名空间信息",
+                        "name": "namespace",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.namespaceOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "命名空间已存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}": {
+            "delete": {
+                "description": "删除命名空间，命名空间下还有release(任意状态)时拒绝删除；kube-system、kube-public、kube-node-lease、default和helm-proxy自己的命名空间不允许删除",
+                "tags": [
+                    "Namespace"
+                ],
+                "summary": "删除命名空间",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "403": {
+                        "description": "受保护的命名空间",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "命名空间不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "命名空间下还有release",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases": {
+            "get": {
+                "description": "根据命名空间获取release信息列表(helm list)，过滤条件使用查询参数",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取命名空间的release列表",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "All ignores the limit/offset",
+                        "name": "all",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "AllNamespaces searches across namespaces",
+                        "name": "all_namespaces",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Overrides the default lexicographic sorting",
+                        "name": "by_date",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "deployed",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "failed",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter is a filter that is applied to the results",
+                        "name": "filter",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit is the number of items to return per Run()",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset is the starting index for the Run() call",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "pending",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "sort_reverse",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "superseded",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "uninstalled",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "uninstalling",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "查询参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}": {
+            "get": {
+                "description": "获取release信息(helm get all)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "升级release(helm upgrade)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "升级release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "description": "升级可选项",
+                        "name": "options",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.releaseOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "安装chart的实例(helm install)。不传chart时可直接提供chart：multipart/form-data上传chart压缩包(chart字段，安装可选项以json放在options字段)，或在json的chart字段中提供ChartView格式的chart；需要校验签名时只能上传压缩包，并在prov字段上传.prov文件",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "安装release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "chart名称",
+                        "name": "chart",
+                        "in": "query"
+                    },
+                    {
+                        "description": "安装可选项",
+                        "name": "options",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.inlineInstallOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release已存在或正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "卸载chart的实例(helm uninstall)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "卸载release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/hooks": {
+            "get": {
+                "description": "获取release的hooks(helm get hooks)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取release的hooks",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/manifest": {
+            "get": {
+                "description": "获取release部署的k8s资源(helm get manifest)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取release的manifest",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/notes": {
+            "get": {
+                "description": "获取release的NOTES.txt(helm get notes)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取release的notes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/recover": {
+            "post": {
+                "description": "release长时间处于pending-install/pending-upgrade/pending-rollback状态时，将其标记为failed，rollback为true时再回滚到最近一次成功的版本",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "恢复卡住的release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "只恢复超过该时间未变化的release，默认5m",
+                        "name": "older_than",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "标记为failed后是否回滚到最近一次成功的版本",
+                        "name": "rollback",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/revisions": {
+            "get": {
+                "description": "获取release历史版本(helm history)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看release历史版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/revisions/{revision}/rollback": {
+            "post": {
+                "description": "回滚release到指定的历史版本(helm rollback)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "release回滚",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "release历史版本号",
+                        "name": "revision",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "版本号错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "release正被其他请求操作",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/status": {
+            "get": {
+                "description": "获取release状态信息(helm status)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看release状态",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/releases/{release}/values": {
+            "get": {
+                "description": "获取release安装时提供的values(helm get values)",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取release的values",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "release名称",
+                        "name": "release",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "release不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/namespaces/{namespace}/upgrades": {
+            "get": {
+                "description": "将命名空间下所有release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看release可升级版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "release所在k8s的命名空间",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "只在指定仓库中查找，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "是否包含预发布版本",
+                        "name": "devel",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/releases": {
+            "get": {
+                "description": "获取所有命名空间的release信息列表(helm list -A)，过滤条件使用查询参数",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "获取所有命名空间的release列表",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "All ignores the limit/offset",
+                        "name": "all",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "AllNamespaces searches across namespaces",
+                        "name": "all_namespaces",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Overrides the default lexicographic sorting",
+                        "name": "by_date",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "deployed",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "failed",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter is a filter that is applied to the results",
+                        "name": "filter",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit is the number of items to return per Run()",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset is the starting index for the Run() call",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "pending",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "sort_reverse",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "superseded",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "uninstalled",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "uninstalling",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "查询参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/repos": {
+            "get": {
+                "description": "列出所有仓库",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "获取所有仓库",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "添加chart仓库或oci registry",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "添加仓库",
+                "parameters": [
+                    {
+                        "description": "仓库信息",
+                        "name": "repoinfo",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.repoAddOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "409": {
+                        "description": "仓库已存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/repos/check": {
+            "post": {
+                "description": "添加仓库前检查DNS解析、TLS握手、认证、index解析，以及证书有效期",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "添加前检查仓库",
+                "parameters": [
+                    {
+                        "description": "仓库信息",
+                        "name": "repoinfo",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.repoAddOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/repos/refresh": {
+            "post": {
+                "description": "更新仓库的index，不指定repos时更新全部仓库，返回每个仓库的更新结果",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "刷新仓库index",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "repo1,repo2,repo3...",
+                        "name": "repos",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/repos/{repo}": {
+            "get": {
+                "description": "获取仓库信息及index刷新状态",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "获取仓库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "修改已添加仓库的地址、认证信息、TLS选项，只修改传入的字段；修改后会下载index验证，验证失败则不做修改",
+                "tags": [
+                    "Repository"
+                ],
+                "summary": "修改仓库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "需要修改的仓库信息",
+                        "name": "repoinfo",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.repoEditOptions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "参数错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "删除仓库及其index缓存",
                 "tags": [
                     "Repository"
                 ],
-                "summary": "删除chart镜像库",
+                "summary": "删除仓库",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "repo1,repo2,repo3...",
-                        "name": "reponame",
+                        "description": "仓库名称",
+                        "name": "repo",
                         "in": "path",
                         "required": true
                     }
@@ -568,17 +2687,86 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/main.respBody"
                         }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
                     }
                 }
             }
         },
-        "/repos/update": {
-            "put": {
-                "description": "更新chart仓库信息",
+        "/v2/repos/{repo}/check": {
+            "post": {
+                "description": "检查已添加仓库的DNS解析、TLS握手、认证、index解析，以及证书有效期",
                 "tags": [
                     "Repository"
                 ],
-                "summary": "更新chart镜像库",
+                "summary": "检查仓库",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "仓库名称",
+                        "name": "repo",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "404": {
+                        "description": "仓库不存在",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/upgrades": {
+            "get": {
+                "description": "将所有命名空间下release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级；没有集群范围的权限时逐个查询有权限的命名空间",
+                "tags": [
+                    "Release"
+                ],
+                "summary": "查看所有命名空间release可升级版本",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "只在指定仓库中查找，repo1,repo2...",
+                        "name": "repo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "是否包含预发布版本",
+                        "name": "devel",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/uploads": {
+            "get": {
+                "description": "列出上传目录中的chart压缩包",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "列出上传的chart",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -587,10 +2775,61 @@ var doc = `{
                         }
                     }
                 }
+            },
+            "post": {
+                "description": "multipart/form-data上传chart压缩包(chart字段)，需要校验签名时.tgz.prov文件同样上传",
+                "tags": [
+                    "Chart"
+                ],
+                "summary": "上传chart",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "chart压缩包或.prov文件",
+                        "name": "chart",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    },
+                    "400": {
+                        "description": "文件错误",
+                        "schema": {
+                            "$ref": "#/definitions/main.respBody"
+                        }
+                    }
+                }
             }
         }
     },
     "definitions": {
+        "main.ChartView": {
+            "type": "object",
+            "properties": {
+                "chart": {
+                    "type": "string"
+                },
+                "readme": {
+                    "type": "string"
+                },
+                "template": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.file"
+                    }
+                },
+                "values": {
+                    "type": "object",
+                    "additionalProperties": true
+                }
+            }
+        },
         "main.chartNew": {
             "type": "object",
             "properties": {
@@ -637,6 +2876,155 @@ var doc = `{
                 }
             }
         },
+        "main.inlineInstallOptions": {
+            "type": "object",
+            "properties": {
+                "atomic": {
+                    "type": "boolean"
+                },
+                "chart": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.ChartView"
+                },
+                "cleanup_on_fail": {
+                    "type": "boolean"
+                },
+                "create_namespace": {
+                    "description": "only install",
+                    "type": "boolean"
+                },
+                "dependency_update": {
+                    "type": "boolean"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "devel": {
+                    "type": "boolean"
+                },
+                "disable_hooks": {
+                    "type": "boolean"
+                },
+                "dry_run": {
+                    "description": "common",
+                    "type": "boolean"
+                },
+                "force": {
+                    "description": "only upgrade",
+                    "type": "boolean"
+                },
+                "install": {
+                    "type": "boolean"
+                },
+                "recreate": {
+                    "type": "boolean"
+                },
+                "set": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "set_string": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "skip_crds": {
+                    "type": "boolean"
+                },
+                "sub_notes": {
+                    "type": "boolean"
+                },
+                "timeout": {
+                    "type": "string"
+                },
+                "values": {
+                    "type": "string"
+                },
+                "wait": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.namespaceOptions": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "quotas": {
+                    "description": "config.yaml中quotaTemplates的名称",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.releaseListOptions": {
+            "type": "object",
+            "properties": {
+                "all": {
+                    "description": "All ignores the limit/offset",
+                    "type": "boolean"
+                },
+                "all_namespaces": {
+                    "description": "AllNamespaces searches across namespaces",
+                    "type": "boolean"
+                },
+                "by_date": {
+                    "description": "Overrides the default lexicographic sorting",
+                    "type": "boolean"
+                },
+                "deployed": {
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "boolean"
+                },
+                "filter": {
+                    "description": "Filter is a filter that is applied to the results",
+                    "type": "string"
+                },
+                "limit": {
+                    "description": "Limit is the number of items to return per Run()",
+                    "type": "integer"
+                },
+                "offset": {
+                    "description": "Offset is the starting index for the Run() call",
+                    "type": "integer"
+                },
+                "pending": {
+                    "type": "boolean"
+                },
+                "sort_reverse": {
+                    "type": "boolean"
+                },
+                "superseded": {
+                    "type": "boolean"
+                },
+                "uninstalled": {
+                    "type": "boolean"
+                },
+                "uninstalling": {
+                    "type": "boolean"
+                }
+            }
+        },
         "main.releaseOptions": {
             "type": "object",
             "properties": {
@@ -726,6 +3114,38 @@ var doc = `{
                 "noUpdate": {
                     "type": "boolean"
                 },
+                "passCredentialsAll": {
+                    "type": "boolean"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.repoEditOptions": {
+            "type": "object",
+            "properties": {
+                "caFile": {
+                    "type": "string"
+                },
+                "certFile": {
+                    "type": "string"
+                },
+                "insecureSkipTLSverify": {
+                    "type": "boolean"
+                },
+                "keyFile": {
+                    "type": "string"
+                },
+                "passCredentialsAll": {
+                    "type": "boolean"
+                },
                 "password": {
                     "type": "string"
                 },
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	auditInterrupted    = "interrupted"
+	interruptedFileName = "interrupted-operations.json"
+)
+
+// 停止服务时的配置
+type shutdownConfig struct {
+	DrainTimeout duration `yaml:"drainTimeout" json:"drainTimeout"` //等待进行中的变更操作完成的最长时间
+	StateFile    string   `yaml:"stateFile" json:"stateFile"`       //记录被中断的release操作，默认为snapPath下的interrupted-operations.json
+	AutoRecover  bool     `yaml:"autoRecover" json:"autoRecover"`   //启动时自动将被中断且仍处于pending状态的release标记为failed
+	Rollback     bool     `yaml:"rollback" json:"rollback"`         //自动恢复时再回滚到最近一次成功的版本
+}
+
+func shutdownSettings() *shutdownConfig {
+	sc := currentConfig().Shutdown
+	if sc == nil {
+		sc = &shutdownConfig{}
+	}
+	return sc
+}
+
+func (sc *shutdownConfig) drainTimeout() time.Duration {
+	if sc.DrainTimeout > 0 {
+		return time.Duration(sc.DrainTimeout)
+	}
+	return defaultDrainTimeout
+}
+
+func (sc *shutdownConfig) stateFile() string {
+	if sc.StateFile != "" {
+		return sc.StateFile
+	}
+	return filepath.Join(currentConfig().SnapPath, interruptedFileName)
+}
+
+// drainer 停止服务时拒绝新的变更请求，并等待进行中的变更请求完成
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+}
+
+var drain = &drainer{}
+
+func isMutatingMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// guard 停止服务期间变更请求返回503
+func (d *drainer) guard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			c.Header("Retry-After", strconv.Itoa(int(shutdownSettings().drainTimeout().Seconds())))
+			respErrStatus(c, http.StatusServiceUnavailable, errors.New("server is shutting down"), nil)
+			c.Abort()
+			return
+		}
+		d.inflight++
+		d.mu.Unlock()
+
+		defer func() {
+			d.mu.Lock()
+			d.inflight--
+			d.mu.Unlock()
+		}()
+		c.Next()
+	}
+}
+
+func (d *drainer) start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draining = true
+}
+
+func (d *drainer) isDraining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// wait 等待进行中的变更请求完成，超时返回false
+func (d *drainer) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		d.mu.Lock()
+		inflight := d.inflight
+		d.mu.Unlock()
+		if inflight == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// interruptedOperation 停止服务时仍未完成的release操作
+type interruptedOperation struct {
+	Namespace   string    `json:"namespace"`
+	Release     string    `json:"release"`
+	Operation   string    `json:"operation"`
+	Holder      string    `json:"holder"`
+	Since       time.Time `json:"since"`
+	Interrupted time.Time `json:"interrupted"`
+}
+
+// interruptedMu 保护状态文件的读取和修改，启动时的检查与恢复接口可能同时修改
+var interruptedMu sync.Mutex
+
+func (op *interruptedOperation) key() string {
+	return strings.Join([]string{op.Namespace, op.Release, op.Operation, op.Interrupted.UTC().Format(time.RFC3339Nano)}, "/")
+}
+
+// recordInterrupted 把仍在进行的release操作追加到状态文件并写入审计日志
+func recordInterrupted(file string, holders []*releaseLockHolder) error {
+	interruptedMu.Lock()
+	defer interruptedMu.Unlock()
+
+	ops, err := readInterrupted(file)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, h := range holders {
+		ops = append(ops, &interruptedOperation{
+			Namespace:   h.Namespace,
+			Release:     h.Release,
+			Operation:   h.Operation,
+			Holder:      h.Holder,
+			Since:       h.Since,
+			Interrupted: now,
+		})
+		auditLog.record(&auditEvent{
+			Time:      now,
+			User:      h.Holder,
+			Operation: h.Operation,
+			Namespace: h.Namespace,
+			Release:   h.Release,
+			Outcome:   auditInterrupted,
+			Error:     "interrupted by server shutdown",
+			Duration:  now.Sub(h.Since).String(),
+		})
+	}
+	return writeInterrupted(file, ops)
+}
+
+func readInterrupted(file string) ([]*interruptedOperation, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ops []*interruptedOperation
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", file)
+	}
+	return ops, nil
+}
+
+func writeInterrupted(file string, ops []*interruptedOperation) error {
+	if len(ops) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// removeInterrupted 通过api恢复release后删除对应的记录
+func removeInterrupted(namespace, name string) {
+	interruptedMu.Lock()
+	defer interruptedMu.Unlock()
+
+	file := shutdownSettings().stateFile()
+	ops, err := readInterrupted(file)
+	if err != nil || len(ops) == 0 {
+		return
+	}
+	remaining := ops[:0]
+	for _, op := range ops {
+		if op.Namespace != namespace || op.Release != name {
+			remaining = append(remaining, op)
+		}
+	}
+	if len(remaining) != len(ops) {
+		if err := writeInterrupted(file, remaining); err != nil {
+			glog.Warningf("failed to update %s: %v", file, err)
+		}
+	}
+}
+
+// checkInterrupted 启动时检查上次停止服务时被中断的release操作，release已不处于pending状态的记录直接删除。
+// 需要访问k8s，在后台运行，不阻塞服务启动
+func checkInterrupted(newConfig func(namespace string) (*action.Configuration, error)) {
+	sc := shutdownSettings()
+	file := sc.stateFile()
+	interruptedMu.Lock()
+	ops, err := readInterrupted(file)
+	interruptedMu.Unlock()
+	if err != nil {
+		glog.Errorf("failed to read interrupted operations: %v", err)
+		return
+	}
+
+	resolved := map[string]bool{}
+	for _, op := range ops {
+		if checkInterruptedOperation(sc, op, newConfig) {
+			resolved[op.key()] = true
+		}
+	}
+	if len(resolved) == 0 {
+		return
+	}
+
+	// 检查期间状态文件可能已被恢复接口修改，重新读取后只删除已处理的记录
+	interruptedMu.Lock()
+	defer interruptedMu.Unlock()
+	ops, err = readInterrupted(file)
+	if err != nil {
+		glog.Errorf("failed to read interrupted operations: %v", err)
+		return
+	}
+	var remaining []*interruptedOperation
+	for _, op := range ops {
+		if !resolved[op.key()] {
+			remaining = append(remaining, op)
+		}
+	}
+	if err := writeInterrupted(file, remaining); err != nil {
+		glog.Errorf("failed to update %s: %v", file, err)
+	}
+}
+
+// checkInterruptedOperation 检查并按配置恢复被中断的操作，返回记录是否可以删除
+func checkInterruptedOperation(sc *shutdownConfig, op *interruptedOperation, newConfig func(namespace string) (*action.Configuration, error)) bool {
+	actionConfig, err := newConfig(op.Namespace)
+	if err != nil {
+		glog.Errorf("failed to check interrupted %s of release %s/%s: %v", op.Operation, op.Namespace, op.Release, err)
+		return false
+	}
+	last, err := actionConfig.Releases.Last(op.Release)
+	if errors.Cause(err) == driver.ErrReleaseNotFound {
+		glog.Warningf("release %s/%s interrupted during %s is not found", op.Namespace, op.Release, op.Operation)
+		return true
+	}
+	if err != nil {
+		glog.Errorf("failed to check interrupted %s of release %s/%s: %v", op.Operation, op.Namespace, op.Release, err)
+		return false
+	}
+	status := last.Info.Status
+	if status != release.StatusPendingInstall && status != release.StatusPendingUpgrade && status != release.StatusPendingRollback {
+		return true
+	}
+	if !sc.AutoRecover {
+		glog.Warningf("release %s/%s is %s after %s was interrupted, recover it with POST /api/namespaces/%s/releases/%s/recover",
+			op.Namespace, op.Release, status, op.Operation, op.Namespace, op.Release)
+		return false
+	}
+	if _, err := recoverPendingRelease(actionConfig, op.Release, 0, sc.Rollback); err != nil {
+		glog.Errorf("failed to recover release %s/%s: %v", op.Namespace, op.Release, err)
+		return false
+	}
+	glog.Infof("release %s/%s interrupted during %s is recovered", op.Namespace, op.Release, op.Operation)
+	return true
+}
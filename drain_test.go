@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestDrainGuard(t *testing.T) {
+	old := helmConfig
+	defer func() { helmConfig = old }()
+	helmConfig = &HelmConfig{Shutdown: &shutdownConfig{DrainTimeout: duration(7 * time.Second)}}
+
+	d := &drainer{}
+	started, finish := make(chan struct{}), make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(d.guard())
+	router.GET("/items", func(c *gin.Context) { respOK(c, nil) })
+	router.POST("/items", func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(started)
+			<-finish
+		}
+		respOK(c, nil)
+	})
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	if !d.wait(time.Millisecond) {
+		t.Error("wait without inflight requests timed out")
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(http.MethodPost, "/items?block=1") }()
+	<-started
+
+	d.start()
+	if !d.isDraining() {
+		t.Error("drainer is not draining after start")
+	}
+	// 停止服务期间新的变更请求返回503，查询请求不受影响
+	w := serve(http.MethodPost, "/items")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "7" {
+		t.Errorf("post during drain: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve(http.MethodGet, "/items"); w.Code != http.StatusOK {
+		t.Errorf("get during drain: %d", w.Code)
+	}
+
+	// 进行中的请求完成前wait超时
+	if d.wait(50 * time.Millisecond) {
+		t.Error("wait returned true while a request is in flight")
+	}
+	close(finish)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("inflight request: %d", w.Code)
+	}
+	if !d.wait(time.Second) {
+		t.Error("wait timed out after the inflight request finished")
+	}
+}
+
+func TestRecordInterrupted(t *testing.T) {
+	auditFile, cleanup := withAuditConfig(t, &auditConfig{})
+	defer cleanup()
+	file := filepath.Join(filepath.Dir(auditFile), "state", interruptedFileName)
+
+	since := time.Now().Add(-time.Minute)
+	holders := []*releaseLockHolder{
+		{Namespace: "team-a", Release: "web", Holder: "alice", Operation: "upgrade", Since: since},
+		{Namespace: "team-b", Release: "db", Holder: "bob", Operation: "install", Since: since},
+	}
+	if err := recordInterrupted(file, holders[:1]); err != nil {
+		t.Fatal(err)
+	}
+	// 再次记录时追加
+	if err := recordInterrupted(file, holders[1:]); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := readInterrupted(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("%d interrupted operations, want 2", len(ops))
+	}
+	for i, op := range ops {
+		h := holders[i]
+		if op.Namespace != h.Namespace || op.Release != h.Release || op.Holder != h.Holder || op.Operation != h.Operation || !op.Since.Equal(since) || op.Interrupted.IsZero() {
+			t.Errorf("operation %d: %+v", i, op)
+		}
+	}
+	if st, err := os.Stat(file); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("state file mode: %v, %v", st, err)
+	}
+
+	f, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var outcomes []string
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		line := scanner.Text()
+		if strings.Contains(line, `"outcome":"`+auditInterrupted+`"`) {
+			outcomes = append(outcomes, line)
+		}
+	}
+	if len(outcomes) != 2 || !strings.Contains(outcomes[0], `"user":"alice"`) || !strings.Contains(outcomes[1], `"release":"db"`) {
+		t.Errorf("audit log: %v", outcomes)
+	}
+
+	// 全部恢复后删除状态文件
+	helmConfig.Shutdown = &shutdownConfig{StateFile: file}
+	removeInterrupted("team-a", "web")
+	if ops, _ := readInterrupted(file); len(ops) != 1 || ops[0].Release != "db" {
+		t.Errorf("after removing team-a/web: %+v", ops)
+	}
+	removeInterrupted("team-b", "db")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("state file is not removed: %v", err)
+	}
+}
+
+func TestCheckInterrupted(t *testing.T) {
+	auditFile, cleanup := withAuditConfig(t, &auditConfig{})
+	defer cleanup()
+	file := filepath.Join(filepath.Dir(auditFile), interruptedFileName)
+	helmConfig.Shutdown = &shutdownConfig{StateFile: file}
+
+	configs := map[string]*action.Configuration{"team-a": memoryActionConfig(t, "team-a")}
+	for _, r := range []*release.Release{
+		testRelease("deployed", "team-a", release.StatusDeployed),
+		testRelease("pending", "team-a", release.StatusPendingUpgrade),
+	} {
+		if err := configs["team-a"].Releases.Create(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newConfig := func(namespace string) (*action.Configuration, error) {
+		if c, ok := configs[namespace]; ok {
+			return c, nil
+		}
+		return nil, errors.Errorf("namespace %s is unreachable", namespace)
+	}
+	holders := []*releaseLockHolder{
+		{Namespace: "team-a", Release: "deployed", Operation: "upgrade"},
+		{Namespace: "team-a", Release: "missing", Operation: "install"},
+		{Namespace: "team-a", Release: "pending", Operation: "upgrade"},
+		{Namespace: "team-b", Release: "unreachable", Operation: "upgrade"},
+	}
+	if err := recordInterrupted(file, holders); err != nil {
+		t.Fatal(err)
+	}
+	remaining := func() []string {
+		ops, err := readInterrupted(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, op := range ops {
+			names = append(names, op.Namespace+"/"+op.Release)
+		}
+		return names
+	}
+
+	// 已不处于pending状态或已删除的release不再记录，pending且未开启自动恢复、无法访问的保留
+	checkInterrupted(newConfig)
+	if got := remaining(); !reflect.DeepEqual(got, []string{"team-a/pending", "team-b/unreachable"}) {
+		t.Errorf("remaining after check: %v", got)
+	}
+	if last, _ := configs["team-a"].Releases.Last("pending"); last.Info.Status != release.StatusPendingUpgrade {
+		t.Errorf("release is %s without autoRecover", last.Info.Status)
+	}
+
+	// 开启自动恢复后pending的release被标记为failed
+	helmConfig.Shutdown.AutoRecover = true
+	checkInterrupted(newConfig)
+	if got := remaining(); !reflect.DeepEqual(got, []string{"team-b/unreachable"}) {
+		t.Errorf("remaining after auto recover: %v", got)
+	}
+	if last, _ := configs["team-a"].Releases.Last("pending"); last.Info.Status != release.StatusFailed {
+		t.Errorf("release is %s after auto recover, want failed", last.Info.Status)
+	}
+}
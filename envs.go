@@ -8,5 +8,9 @@ import "github.com/gin-gonic/gin"
 // @Success 	200 {object} respBody
 // @Router 		/envs [get]
 func getHelmEnvs(c *gin.Context) {
-	respOK(c, settings.EnvVars())
+	envs := settings.EnvVars()
+	if envs["HELM_KUBETOKEN"] != "" {
+		envs["HELM_KUBETOKEN"] = maskedValue
+	}
+	respOK(c, envs)
 }
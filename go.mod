@@ -6,14 +6,25 @@ require (
 	github.com/Masterminds/semver v1.5.0
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
 	github.com/chartmuseum/helm-push v0.7.1
-	github.com/gin-gonic/gin v1.6.3
+	github.com/containerd/containerd v1.3.4
+	github.com/deislabs/oras v0.8.1
+	github.com/gin-gonic/gin v1.7.7 // >= v1.7.2: static and :param routes under the same path segment (gin#2706)
 	github.com/gofrs/flock v0.7.1
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
+	github.com/opencontainers/image-spec v1.0.1
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.3.0
 	github.com/spf13/pflag v1.0.5
 	github.com/swaggo/gin-swagger v1.2.0
 	github.com/swaggo/swag v1.6.7
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	gopkg.in/yaml.v3 v3.0.1
 	helm.sh/helm/v3 v3.3.0
+	k8s.io/api v0.18.4
+	k8s.io/apimachinery v0.18.4
+	k8s.io/cli-runtime v0.18.4
+	k8s.io/client-go v0.18.4
 	k8s.io/helm v2.16.12+incompatible // indirect
 	rsc.io/letsencrypt v0.0.3 // indirect
 	sigs.k8s.io/yaml v1.2.0
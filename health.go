@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/kube"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const readyCheckTimeout = 5 * time.Second
+
+// readyCheck 单项就绪检查的结果
+type readyCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Optional bool   `json:"optional,omitempty"` // 失败不影响就绪，如proxy未使用的kube context
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// healthz 存活检查，进程能处理请求即返回200，供k8s的livenessProbe使用
+func healthz(c *gin.Context) {
+	respOK(c, "ok")
+}
+
+// readyz 就绪检查：是否正在停止服务、kubeconfig中各context的k8s API是否可访问、UploadPath和SnapPath是否可写、
+// 各仓库的index文件是否存在，除optional的检查外全部通过返回200，否则返回503，data中是每项检查的结果
+func readyz(c *gin.Context) {
+	checks := runReadyChecks()
+	var failed []string
+	for _, check := range checks {
+		if !check.OK && !check.Optional {
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) > 0 {
+		c.JSON(http.StatusServiceUnavailable, &respBody{
+			Code:  1,
+			Data:  checks,
+			Error: "not ready: " + strings.Join(failed, ", "),
+		})
+		return
+	}
+	respOK(c, checks)
+}
+
+type readyCheckFunc struct {
+	name     string
+	fn       func() (string, error)
+	optional bool
+}
+
+// runReadyChecks 并发执行所有检查，结果顺序固定
+func runReadyChecks() []*readyCheck {
+	funcs := []readyCheckFunc{
+		{name: "shutdown", fn: checkDraining},
+	}
+	funcs = append(funcs, kubernetesChecks()...)
+	funcs = append(funcs,
+		readyCheckFunc{name: "upload-path", fn: func() (string, error) { return checkWritable(currentConfig().UploadPath) }},
+		readyCheckFunc{name: "snap-path", fn: func() (string, error) { return checkWritable(currentConfig().SnapPath) }},
+	)
+	for _, r := range repositories.all() {
+		// oci仓库没有index文件
+		if isOCIReference(r.URL) {
+			continue
+		}
+		name := r.Name
+		funcs = append(funcs, readyCheckFunc{name: "repo-index/" + name, fn: func() (string, error) { return checkRepoIndex(name) }})
+	}
+
+	checks := make([]*readyCheck, len(funcs))
+	var wg sync.WaitGroup
+	for i, f := range funcs {
+		wg.Add(1)
+		go func(i int, f readyCheckFunc) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := f.fn()
+			check := &readyCheck{Name: f.name, OK: err == nil, Optional: f.optional, Detail: detail, Duration: time.Since(start).String()}
+			if err != nil {
+				check.Error = secrets.mask(err.Error())
+			}
+			checks[i] = check
+		}(i, f)
+	}
+	wg.Wait()
+	return checks
+}
+
+// checkDraining 停止服务期间不再接收流量
+func checkDraining() (string, error) {
+	if drain.isDraining() {
+		return "", errors.New("server is shutting down")
+	}
+	return "", nil
+}
+
+// kubernetesChecks kubeconfig中每个context一项检查，只有proxy使用的context(--kube-context或current-context)影响就绪；
+// 没有kubeconfig(如在集群内运行)时只检查proxy使用的配置
+func kubernetesChecks() []readyCheckFunc {
+	used := func() (string, error) { return checkKubernetes(kubeClientConfig("")) }
+	contexts, current := kubeContexts()
+	if len(contexts) == 0 {
+		return []readyCheckFunc{{name: "kubernetes", fn: used}}
+	}
+
+	checks := make([]readyCheckFunc, 0, len(contexts)+1)
+	// 使用的context不在kubeconfig中时，仍由这一项报告错误
+	if _, ok := contexts[current]; !ok {
+		name := "kubernetes"
+		if current != "" {
+			name += "/" + current
+		}
+		checks = append(checks, readyCheckFunc{name: name, fn: used})
+	}
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == current {
+			checks = append(checks, readyCheckFunc{name: "kubernetes/" + name, fn: used})
+			continue
+		}
+		name := name
+		checks = append(checks, readyCheckFunc{name: "kubernetes/" + name, optional: true, fn: func() (string, error) {
+			return checkKubernetes(kube.GetConfig(settings.KubeConfig, name, ""))
+		}})
+	}
+	return checks
+}
+
+// kubeContexts kubeconfig中的context和proxy使用的context名称
+func kubeContexts() (map[string]*clientcmdapi.Context, string) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = settings.KubeConfig
+	config, err := rules.Load()
+	if err != nil {
+		return nil, ""
+	}
+	current := settings.KubeContext
+	if current == "" {
+		current = config.CurrentContext
+	}
+	return config.Contexts, current
+}
+
+// checkKubernetes 请求API server的版本
+func checkKubernetes(getter genericclioptions.RESTClientGetter) (string, error) {
+	restConfig, err := getter.ToRESTConfig()
+	if err != nil {
+		return "", err
+	}
+	restConfig.Timeout = readyCheckTimeout
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return "", err
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return restConfig.Host + " " + version.GitVersion, nil
+}
+
+// checkWritable 在目录下创建并删除临时文件
+func checkWritable(dir string) (string, error) {
+	f, err := ioutil.TempFile(dir, ".readyz-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return dir, os.Remove(f.Name())
+}
+
+func checkRepoIndex(name string) (string, error) {
+	file := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(name))
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "index file of repo %s is missing", name)
+	}
+	return "updated " + info.ModTime().Format(time.RFC3339), nil
+}
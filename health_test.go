@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestRunReadyChecksSkipsOCIRepos(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	repositories.repos = append(repositories.repos, &repoConfig{Entry: repo.Entry{Name: "oci", URL: "oci://registry.example.com/charts"}})
+
+	checks := map[string]*readyCheck{}
+	for _, check := range runReadyChecks() {
+		checks[check.Name] = check
+	}
+	if check, ok := checks["repo-index/stable"]; !ok || !check.OK {
+		t.Errorf("index of stable is not checked or not ready: %+v", check)
+	}
+	if check, ok := checks["repo-index/oci"]; ok {
+		t.Errorf("oci repository has no index file, but it is checked: %+v", check)
+	}
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: up
+  cluster:
+    server: %s
+- name: down
+  cluster:
+    server: %s
+contexts:
+- name: used
+  context:
+    cluster: up
+- name: other
+  context:
+    cluster: down
+current-context: used
+`
+
+func TestReadyChecksPerKubeContext(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"18","gitVersion":"v1.18.4"}`))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	dir, cleanup := setupApplyConfig(t)
+	defer cleanup()
+	helmConfig = &HelmConfig{UploadPath: dir, SnapPath: dir}
+	oldKubeConfig, oldContext := settings.KubeConfig, settings.KubeContext
+	defer func() { settings.KubeConfig, settings.KubeContext = oldKubeConfig, oldContext }()
+	settings.KubeConfig = filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(settings.KubeConfig, []byte(fmt.Sprintf(testKubeconfig, up.URL, down.URL)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/readyz", readyz)
+	ready := func() (int, map[string]*readyCheck) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var list []*readyCheck
+		if err := json.Unmarshal(w.Body.Bytes(), &respBody{Data: &list}); err != nil {
+			t.Fatal(err)
+		}
+		checks := map[string]*readyCheck{}
+		for _, check := range list {
+			checks[check.Name] = check
+		}
+		return w.Code, checks
+	}
+
+	// 未使用的context不可访问时仍然就绪，但单独报告
+	code, checks := ready()
+	if code != http.StatusOK {
+		t.Errorf("readyz status %d, want 200: %+v", code, checks)
+	}
+	if check := checks["kubernetes/used"]; check == nil || !check.OK || check.Optional || !strings.Contains(check.Detail, "v1.18.4") {
+		t.Errorf("used context check %+v", check)
+	}
+	if check := checks["kubernetes/other"]; check == nil || check.OK || !check.Optional {
+		t.Errorf("other context check %+v", check)
+	}
+
+	// 使用的context不可访问时不就绪
+	settings.KubeContext = "other"
+	code, checks = ready()
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("readyz status %d, want 503", code)
+	}
+	if check := checks["kubernetes/other"]; check == nil || check.OK || check.Optional {
+		t.Errorf("used context check %+v", check)
+	}
+	if check := checks["kubernetes/used"]; check == nil || !check.OK || !check.Optional {
+		t.Errorf("unused context check %+v", check)
+	}
+
+	// 指定的context不在kubeconfig中
+	settings.KubeContext = "missing"
+	if code, checks = ready(); code != http.StatusServiceUnavailable || checks["kubernetes/missing"] == nil || checks["kubernetes/missing"].OK {
+		t.Errorf("readyz with a missing context: %d %+v", code, checks["kubernetes/missing"])
+	}
+}
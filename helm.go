@@ -1,15 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
-	"github.com/golang/glog"
+	"github.com/gin-gonic/gin"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/kube"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 )
 
-func actionConfigInit(namespace string) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
+func kubeClientConfig(namespace string) *genericclioptions.ConfigFlags {
 	clientConfig := kube.GetConfig(settings.KubeConfig, settings.KubeContext, namespace)
 	if settings.KubeToken != "" {
 		clientConfig.BearerToken = &settings.KubeToken
@@ -17,11 +19,34 @@ func actionConfigInit(namespace string) (*action.Configuration, error) {
 	if settings.KubeAPIServer != "" {
 		clientConfig.APIServer = &settings.KubeAPIServer
 	}
-	err := actionConfig.Init(clientConfig, namespace, os.Getenv("HELM_DRIVER"), glog.Infof)
+	return clientConfig
+}
+
+// kubeClientset 直接操作k8s资源(命名空间、Secret等)的客户端
+func kubeClientset(namespace string) (kubernetes.Interface, error) {
+	restConfig, err := kubeClientConfig(namespace).ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// actionConfigInit helm的debug日志带上请求的request_id等信息
+func actionConfigInit(c *gin.Context, namespace string) (*action.Configuration, error) {
+	actionConfig, err := newActionConfig(namespace, requestLogf(c))
 	if err != nil {
-		glog.Errorf("%+v", err)
+		logRequest(c, "error", fmt.Sprintf("%+v", err))
 		return nil, err
 	}
 
 	return actionConfig, nil
 }
+
+// newActionConfig 不在请求中使用时(如启动时)自行指定日志函数；测试中替换为内存存储
+var newActionConfig = func(namespace string, log action.DebugLog) (*action.Configuration, error) {
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(kubeClientConfig(namespace), namespace, os.Getenv("HELM_DRIVER"), log); err != nil {
+		return nil, err
+	}
+	return actionConfig, nil
+}
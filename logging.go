@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	ctxRequestIDKey = "helm-proxy/request-id"
+
+	maxRequestIDLength = 128
+)
+
+// 客户端传入的X-Request-ID会写入日志和响应头，只接受这些字符，避免伪造日志字段或注入响应头
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// jsonLogger 每行一个JSON对象的结构化日志
+type jsonLogger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// glog、klog的文本日志写到stderr，JSON日志单独写到stdout，日志采集时两种格式不会混在同一个流中
+var structuredLog = &jsonLogger{out: os.Stdout}
+
+func (l *jsonLogger) log(level, msg string, fields map[string]interface{}) {
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		if v != "" && v != nil {
+			entry[k] = v
+		}
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = secrets.mask(msg)
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(b, '\n'))
+}
+
+// requestFields 请求相关的日志字段，同一请求的日志都带有相同的request_id
+func requestFields(c *gin.Context) map[string]interface{} {
+	return map[string]interface{}{
+		"request_id": c.GetString(ctxRequestIDKey),
+		"user":       requestUser(c),
+		"method":     c.Request.Method,
+		"route":      c.FullPath(),
+		"namespace":  c.Param("namespace"),
+		"release":    c.Param("release"),
+	}
+}
+
+func logRequest(c *gin.Context, level, msg string) {
+	structuredLog.log(level, msg, requestFields(c))
+}
+
+// requestLogf 传给helm的debug日志函数，helm输出的日志也带上请求信息
+func requestLogf(c *gin.Context) func(format string, v ...interface{}) {
+	fields := requestFields(c)
+	fields["source"] = "helm"
+	return func(format string, v ...interface{}) {
+		structuredLog.log("debug", fmt.Sprintf(format, v...), fields)
+	}
+}
+
+// requestLogger 分配或沿用X-Request-ID，并在请求结束后输出一行访问日志
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(ctxRequestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+
+		fields := requestFields(c)
+		fields["path"] = c.Request.URL.Path
+		fields["status"] = c.Writer.Status()
+		fields["duration"] = time.Since(start).String()
+		fields["client_ip"] = clientIP(c)
+		fields["error"] = c.GetString(ctxErrorKey)
+		structuredLog.log("info", "request", fields)
+	}
+}
+
+func validRequestID(id string) bool {
+	return len(id) <= maxRequestIDLength && requestIDRegexp.MatchString(id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
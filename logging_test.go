@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLoggerRequestID(t *testing.T) {
+	var out bytes.Buffer
+	old := structuredLog
+	structuredLog = &jsonLogger{out: &out}
+	defer func() { structuredLog = old }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestLogger())
+	router.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(ctxRequestIDKey)) })
+
+	tests := []struct {
+		id   string
+		keep bool
+	}{
+		{"abc-123_DEF.4", true},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{"", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+		{"id with spaces", false},
+		{`id","level":"error`, false},
+		{"idé", false},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set(requestIDHeader, tt.id)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if tt.keep && id != tt.id {
+			t.Errorf("request id %q is replaced by %q", tt.id, id)
+		}
+		if !tt.keep && (id == tt.id || !validRequestID(id) || len(id) != 32) {
+			t.Errorf("request id %q is not replaced by a generated one: %q", tt.id, id)
+		}
+		if w.Body.String() != id {
+			t.Errorf("request id in context is %q, want %q", w.Body.String(), id)
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("access log is not a single JSON line: %v %q", err, out.String())
+		}
+		if entry["request_id"] != id || entry["level"] != "info" {
+			t.Errorf("access log %v, want request_id %q", entry, id)
+		}
+	}
+}
@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
@@ -17,18 +16,56 @@ import (
 	"github.com/spf13/pflag"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
+	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
-	"helm.sh/helm/v3/pkg/repo"
-	"sigs.k8s.io/yaml"
 
 	"helm-proxy/docs"
 )
 
 type HelmConfig struct {
-	UploadPath   string        `yaml:"uploadPath"`   //chart的上传路径
-	TemplatePath string        `yaml:"templatePath"` //chart的模板路径
-	SnapPath     string        `yaml:"snapPath"`     //上传chart库前的临时路径
-	HelmRepos    []*repo.Entry `yaml:"helmRepos"`
+	UploadPath   string             `yaml:"uploadPath" json:"uploadPath"`     //chart的上传路径
+	TemplatePath string             `yaml:"templatePath" json:"templatePath"` //chart的模板路径
+	SnapPath     string             `yaml:"snapPath" json:"snapPath"`         //上传chart库前的临时路径
+	HelmRepos    []*repoConfig      `yaml:"helmRepos" json:"helmRepos"`
+	Registries   []*registryConfig  `yaml:"registries" json:"registries"`   //OCI镜像仓库登录信息
+	RepoRefresh  *repoRefreshConfig `yaml:"repoRefresh" json:"repoRefresh"` //仓库index定时刷新
+	Provenance   *provenanceConfig  `yaml:"provenance" json:"provenance"`   //chart签名与校验
+	ReleaseLock  *releaseLockConfig `yaml:"releaseLock" json:"releaseLock"` //release操作互斥
+	Audit        *auditConfig       `yaml:"audit" json:"audit"`             //审计日志
+	TLS          *tlsConfig         `yaml:"tls" json:"tls"`                 //HTTPS及客户端证书校验
+	UnixSocket   string             `yaml:"unixSocket" json:"unixSocket"`   //同时监听的unix socket，供sidecar使用，不校验客户端证书
+	Shutdown     *shutdownConfig    `yaml:"shutdown" json:"shutdown"`       //停止服务时等待进行中的操作
+	RateLimit    *rateLimitConfig   `yaml:"rateLimit" json:"rateLimit"`     //请求限流和变更操作并发数限制
+
+	QuotaTemplates map[string]*quotaTemplate `yaml:"quotaTemplates" json:"quotaTemplates"` //创建命名空间时可引用的资源配额模板
+	TrustedProxies []string                  `yaml:"trustedProxies" json:"trustedProxies"` //信任的反向代理(IP或CIDR)，只有来自这些地址的请求才使用X-Forwarded-For
+}
+
+// duration 配置文件中的时间间隔，支持"30s"、"10m"格式，数字按秒计算
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = duration(time.Duration(value * float64(time.Second)))
+	case string:
+		t, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = duration(t)
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
 }
 
 var (
@@ -36,7 +73,7 @@ var (
 	defaultUploadPath   = "./charts/upload"
 	defaultTemplatePath = "./charts/template"
 	defaultSnapPath     = "./charts/snap"
-	helmConfig          = &HelmConfig{}
+	configFile          string
 )
 
 // 跨域
@@ -48,7 +85,8 @@ func cors() gin.HandlerFunc {
 		}
 		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, DELETE")
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 		if c.Request.Method == "OPTIONS" {
@@ -68,84 +106,91 @@ func cors() gin.HandlerFunc {
 // @license.url http://www.apache.org/licenses/LICENSE-2.0.html
 func main() {
 	var (
-		listenHost string
-		listenPort string
-		config     string
+		listenHost  string
+		listenPort  string
+		configWatch time.Duration
+		validate    bool
 	)
 
 	flag.Set("logtostderr", "true")
 	pflag.CommandLine.StringVar(&listenHost, "addr", "127.0.0.1", "server listen addr")
 	pflag.CommandLine.StringVar(&listenPort, "port", "18080", "server listen port")
-	pflag.CommandLine.StringVar(&config, "config", "config.yaml", "helm proxy config")
+	pflag.CommandLine.StringVar(&configFile, "config", "config.yaml", "helm proxy config")
+	pflag.CommandLine.DurationVar(&configWatch, "config-watch-interval", defaultConfigWatchInterval, "interval to check config file changes, 0 to disable")
+	pflag.CommandLine.BoolVar(&validate, "validate-config", false, "validate the config (with HELM_PROXY_* environment overrides) and exit")
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	settings.AddFlags(pflag.CommandLine)
 	pflag.Parse()
 	defer glog.Flush()
 
-	configBody, err := ioutil.ReadFile(config)
-	if err != nil {
-		glog.Fatalln(err)
+	conf, err := loadConfig(configFile)
+	if validate {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("config is valid")
+		return
 	}
-	err = yaml.Unmarshal(configBody, helmConfig)
 	if err != nil {
 		glog.Fatalln(err)
 	}
-
-	// upload chart path
-	if helmConfig.UploadPath == "" {
-		helmConfig.UploadPath = defaultUploadPath
-	} else {
-		if !filepath.IsAbs(helmConfig.UploadPath) {
-			glog.Fatalln("charts upload path is not absolute")
-		}
-	}
-	// chart template path
-	if helmConfig.TemplatePath == "" {
-		helmConfig.TemplatePath = defaultTemplatePath
-	} else {
-		if !filepath.IsAbs(helmConfig.TemplatePath) {
-			glog.Fatalln("charts template path is not absolute")
-		}
-	}
-	// chart snap path
-	if helmConfig.SnapPath == "" {
-		helmConfig.SnapPath = defaultSnapPath
-	} else {
-		if !filepath.IsAbs(helmConfig.SnapPath) {
-			glog.Fatalln("charts snap path is not absolute")
-		}
-	}
-	_, err = os.Stat(helmConfig.UploadPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = os.MkdirAll(helmConfig.UploadPath, 0755)
-			if err != nil {
-				glog.Fatalln(err)
-			}
-		} else {
+	secrets.add(settings.KubeToken)
+	if conf.TLS != nil {
+		certs, err = newCertReloader(conf.TLS)
+		if err != nil {
 			glog.Fatalln(err)
 		}
 	}
+	// 下载仓库index并加载仓库列表
+	if err := applyConfig(conf); err != nil {
+		glog.Fatalln(err)
+	}
+	configReload.loaded(configFile)
+	go checkInterrupted(func(namespace string) (*action.Configuration, error) {
+		return newActionConfig(namespace, glog.V(4).Infof)
+	})
 
-	// init repo
-	for _, c := range helmConfig.HelmRepos {
-		err = initRepository(c)
-		if err != nil {
-			glog.Fatalln(err)
-		}
+	refresher.start()
+	defer refresher.shutdown()
+
+	// 配置文件变化或收到SIGHUP时重新加载
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	if configWatch > 0 {
+		go configReload.watch(configFile, configWatch, stopWatch)
 	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			configReload.reload(configFile, "SIGHUP")
+		}
+	}()
 
 	// router
-	router := gin.Default()
+	// stdout只输出JSON日志，gin的调试信息也写到stderr
+	gin.DefaultWriter = os.Stderr
+	router, err := newEngine(conf.TrustedProxies)
+	if err != nil {
+		glog.Fatalln(err)
+	}
+	router.Use(tlsIdentity())
+	router.Use(requestLogger())
 	router.Use(cors()) //跨域设置
 	router.Use(gin.Recovery())
+	router.Use(metrics())
+	router.Use(drain.guard())
 	router.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "Welcome helm proxy server")
 	})
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
 
 	// swago定义
 	docs.SwaggerInfo.Host = listenHost + ":" + listenPort
 	docs.SwaggerInfo.BasePath = "/api/"
+	router.GET("/metrics", metricsHandler())
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL("/swagger/doc.json")))
 
 	// register router
@@ -156,17 +201,53 @@ func main() {
 		Handler: router,
 	}
 
+	if certs != nil {
+		srv.TLSConfig = certs.tlsConfig()
+	}
+
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			glog.Fatalf("listen: %s\n", err)
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	if conf.UnixSocket != "" {
+		if conf.TLS != nil && conf.TLS.ClientCAFile != "" {
+			glog.Warningf("unix socket %s is served without client certificate verification", conf.UnixSocket)
+		}
+		l, err := listenUnix(conf.UnixSocket)
+		if err != nil {
+			glog.Fatalln(err)
+		}
+		go func() {
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				glog.Fatalf("listen: %s\n", err)
+			}
+		}()
+	}
+
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	glog.Infoln("Shutdown Server ...")
 
+	// 不再接受变更请求，等待进行中的操作完成，超时仍未完成的release操作记录下来供重启后恢复
+	drain.start()
+	sc := shutdownSettings()
+	if !drain.wait(sc.drainTimeout()) {
+		interrupted := releaseLocks.list()
+		glog.Warningf("%d release operations are still running after %s", len(interrupted), sc.drainTimeout())
+		if err := recordInterrupted(sc.stateFile(), interrupted); err != nil {
+			glog.Errorf("failed to record interrupted operations: %v", err)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "helm_proxy"
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "Number of mutating operations (helm actions, repo and chart changes) by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Durations of mutating operations by operation and outcome.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600},
+	}, []string{"operation", "outcome"})
+
+	releaseOperationsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "release_operations_in_flight",
+		Help:      "Number of release operations currently running by operation.",
+	}, []string{"operation"})
+
+	repoRefreshFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "repo_refresh_failures_total",
+		Help:      "Number of failed repository index downloads by repository.",
+	}, []string{"repo"})
+
+	uploadSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_size_bytes",
+		Help:      "Sizes of uploaded chart files.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	}, []string{"kind"})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected with 429 by reason (rate or concurrency).",
+	}, []string{"reason"})
+
+	repoIndexAgeDesc = prometheus.NewDesc(metricsNamespace+"_repo_index_age_seconds",
+		"Seconds since the repository index was last downloaded successfully.", []string{"repo"}, nil)
+	repoConsecutiveFailuresDesc = prometheus.NewDesc(metricsNamespace+"_repo_refresh_consecutive_failures",
+		"Number of consecutive failed index downloads of the repository.", []string{"repo"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestsTotal,
+		httpRequestDuration,
+		operationsTotal,
+		operationDuration,
+		releaseOperationsInFlight,
+		repoRefreshFailuresTotal,
+		uploadSizeBytes,
+		rateLimitedTotal,
+		repoRefreshCollector{},
+	)
+}
+
+// repoRefreshCollector 采集时从refresher读取每个仓库的index刷新状态
+type repoRefreshCollector struct{}
+
+func (repoRefreshCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- repoIndexAgeDesc
+	ch <- repoConsecutiveFailuresDesc
+}
+
+func (repoRefreshCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, s := range refresher.list() {
+		if s.LastSuccess != nil {
+			ch <- prometheus.MustNewConstMetric(repoIndexAgeDesc, prometheus.GaugeValue, now.Sub(*s.LastSuccess).Seconds(), s.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(repoConsecutiveFailuresDesc, prometheus.GaugeValue, float64(s.ConsecutiveFailures), s.Name)
+	}
+}
+
+// metrics 统计每个请求的数量和耗时，route为注册的路由而不是实际路径
+func metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+func observeOperation(operation, outcome string, d time.Duration) {
+	operationsTotal.WithLabelValues(operation, outcome).Inc()
+	operationDuration.WithLabelValues(operation, outcome).Observe(d.Seconds())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsRouteLabel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metrics())
+	router.GET("/metrics-test/items/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	counter := httpRequestsTotal.WithLabelValues(http.MethodGet, "/metrics-test/items/:id", "204")
+	before := testutil.ToFloat64(counter)
+	unmatched := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, "unmatched", "404"))
+	for _, path := range []string{"/metrics-test/items/1", "/metrics-test/items/2", "/metrics-test/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(counter) - before; got != 2 {
+		t.Errorf("requests of the route increased by %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, "unmatched", "404")) - unmatched; got != 1 {
+		t.Errorf("unmatched requests increased by %v, want 1", got)
+	}
+
+	// 实际路径不作为标签，避免标签数量无限增长
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), metricsNamespace+"_http_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "route" && strings.HasPrefix(l.GetValue(), "/metrics-test/") && l.GetValue() != "/metrics-test/items/:id" {
+					t.Errorf("%s has a raw path route label %q", mf.GetName(), l.GetValue())
+				}
+			}
+		}
+	}
+}
+
+func TestMetricsOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metrics())
+	router.POST("/metrics-ops/ok", audit("metrics-test"), func(c *gin.Context) { respOK(c, nil) })
+	router.POST("/metrics-ops/fail", audit("metrics-test"), func(c *gin.Context) { respErr(c, errors.New("failed")) })
+	router.GET("/metrics", metricsHandler())
+
+	success := operationsTotal.WithLabelValues("metrics-test", auditSuccess)
+	failure := operationsTotal.WithLabelValues("metrics-test", auditFailure)
+	successBefore, failureBefore := testutil.ToFloat64(success), testutil.ToFloat64(failure)
+	for _, path := range []string{"/metrics-ops/ok", "/metrics-ops/ok", "/metrics-ops/fail"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+	if got := testutil.ToFloat64(success) - successBefore; got != 2 {
+		t.Errorf("successful operations: %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(failure) - failureBefore; got != 1 {
+		t.Errorf("failed operations: %v, want 1", got)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, want := range []string{
+		`helm_proxy_operations_total{operation="metrics-test",outcome="success"}`,
+		`helm_proxy_operation_duration_seconds_count{operation="metrics-test",outcome="failure"}`,
+		`helm_proxy_http_requests_total{method="POST",route="/metrics-ops/ok",status="200"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics does not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// 资源配额模板，创建命名空间时按名称引用
+type quotaTemplate struct {
+	Hard   map[string]string `yaml:"hard" json:"hard"`     //如 requests.cpu: "2"、pods: "20"
+	Scopes []string          `yaml:"scopes" json:"scopes"` //如 NotTerminating
+}
+
+type namespaceElement struct {
+	Name        string            `json:"name"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Releases    int               `json:"releases"`
+	Created     time.Time         `json:"created"`
+}
+
+type namespaceOptions struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Quotas      []string          `json:"quotas"` //config.yaml中quotaTemplates的名称
+}
+
+// @Summary			获取命名空间列表
+// @Description 	列出所有命名空间及其标签和release数量
+// @Tags			Namespace
+// @Param 			has_releases query bool false "true只列出有release的命名空间；false只列出没有release的命名空间；不传则都列出"
+// @Success 		200 {object} respBody
+// @Router 			/namespaces [get]
+func listNamespaces(c *gin.Context) {
+	clientset, err := kubeClientset("")
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	actionConfig, err := actionConfigInit(c, "")
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	counts, err := releaseCounts(actionConfig)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+
+	namespaces, err := namespaceList(clientset, counts)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	namespaces, err = filterNamespaces(namespaces, c.Query("has_releases"))
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, namespaces)
+}
+
+// filterNamespaces hasReleases为空时不过滤
+func filterNamespaces(namespaces []namespaceElement, hasReleases string) ([]namespaceElement, error) {
+	if hasReleases == "" {
+		return namespaces, nil
+	}
+	b, err := strconv.ParseBool(hasReleases)
+	if err != nil {
+		return nil, badRequestf("bad has_releases %q", hasReleases)
+	}
+	filtered := make([]namespaceElement, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if (ns.Releases > 0) == b {
+			filtered = append(filtered, ns)
+		}
+	}
+	return filtered, nil
+}
+
+func namespaceList(clientset kubernetes.Interface, counts map[string]int) ([]namespaceElement, error) {
+	list, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make([]namespaceElement, 0, len(list.Items))
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, namespaceElement{
+			Name:        ns.Name,
+			Status:      string(ns.Status.Phase),
+			Labels:      ns.Labels,
+			Annotations: ns.Annotations,
+			Releases:    counts[ns.Name],
+			Created:     ns.CreationTimestamp.Time,
+		})
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces, nil
+}
+
+// releaseCounts 每个命名空间下所有状态的release数量
+func releaseCounts(actionConfig *action.Configuration) (map[string]int, error) {
+	client := action.NewList(actionConfig)
+	client.AllNamespaces = true
+	client.All = true
+	client.SetStateMask()
+	results, err := client.Run()
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Namespace]++
+	}
+	return counts, nil
+}
+
+// @Summary			创建命名空间
+// @Description 	创建命名空间，可设置标签、注解，并按config.yaml中的quotaTemplates创建资源配额
+// @Tags			Namespace
+// @Param 			namespace body namespaceOptions true "命名空间信息"
+// @Success 		200 {object} respBody
+// @Router 			/namespaces [post]
+func createNamespace(c *gin.Context) {
+	var o namespaceOptions
+	if err := c.BindJSON(&o); err != nil || o.Name == "" {
+		respErr(c, badRequestf("missing parameters"))
+		return
+	}
+	clientset, err := kubeClientset("")
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	ns, err := newNamespace(clientset, &o)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, ns)
+}
+
+func newNamespace(clientset kubernetes.Interface, o *namespaceOptions) (*namespaceElement, error) {
+	// 先校验配额模板，避免命名空间创建后配额创建失败
+	quotas := make([]*corev1.ResourceQuota, 0, len(o.Quotas))
+	for _, name := range o.Quotas {
+		quota, err := newResourceQuota(o.Name, name)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        o.Name,
+			Labels:      o.Labels,
+			Annotations: o.Annotations,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, quota := range quotas {
+		if _, err := clientset.CoreV1().ResourceQuotas(o.Name).Create(context.Background(), quota, metav1.CreateOptions{}); err != nil {
+			return nil, errors.Wrapf(err, "namespace %s is created, but failed to create resource quota %s", o.Name, quota.Name)
+		}
+	}
+
+	return &namespaceElement{
+		Name:        ns.Name,
+		Status:      string(ns.Status.Phase),
+		Labels:      ns.Labels,
+		Annotations: ns.Annotations,
+		Created:     ns.CreationTimestamp.Time,
+	}, nil
+}
+
+func newResourceQuota(namespace, template string) (*corev1.ResourceQuota, error) {
+	t, ok := currentConfig().QuotaTemplates[template]
+	if !ok {
+		return nil, errors.Errorf("no quota template named %q found", template)
+	}
+	hard := corev1.ResourceList{}
+	for name, value := range t.Hard {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad quantity %q of %s in quota template %s", value, name, template)
+		}
+		hard[corev1.ResourceName(name)] = q
+	}
+	scopes := make([]corev1.ResourceQuotaScope, 0, len(t.Scopes))
+	for _, s := range t.Scopes {
+		scopes = append(scopes, corev1.ResourceQuotaScope(s))
+	}
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: template, Namespace: namespace},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard, Scopes: scopes},
+	}, nil
+}
+
+// @Summary			删除命名空间
+// @Description 	删除命名空间，命名空间下还有release(任意状态)时拒绝删除；kube-system、kube-public、kube-node-lease、default和helm-proxy自己的命名空间不允许删除
+// @Tags			Namespace
+// @Param 			namespace path string true "k8s的命名空间"
+// @Success 		200 {object} respBody
+// @Router 			/namespaces/{namespace} [delete]
+func deleteNamespace(c *gin.Context) {
+	namespace := c.Param("namespace")
+	if protectedNamespace(namespace) {
+		respErr(c, forbiddenf("namespace %s is protected and can not be deleted", namespace))
+		return
+	}
+	actionConfig, err := actionConfigInit(c, namespace)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	clientset, err := kubeClientset(namespace)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	if names, err := removeNamespace(clientset, actionConfig, namespace); err != nil {
+		respErrData(c, err, names)
+		return
+	}
+	respOK(c, nil)
+}
+
+// protectedNamespace 系统命名空间和helm-proxy自己使用的命名空间(release锁的Lease所在命名空间)
+func protectedNamespace(namespace string) bool {
+	switch namespace {
+	case metav1.NamespaceSystem, metav1.NamespacePublic, corev1.NamespaceNodeLease, metav1.NamespaceDefault:
+		return true
+	}
+	return namespace == settings.Namespace() || namespace == lockConfig().namespace()
+}
+
+// removeNamespace 命名空间下还有release时不删除，返回这些release的名称
+func removeNamespace(clientset kubernetes.Interface, actionConfig *action.Configuration, namespace string) ([]string, error) {
+	client := action.NewList(actionConfig)
+	client.All = true
+	client.SetStateMask()
+	results, err := client.Run()
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > 0 {
+		names := make([]string, 0, len(results))
+		for _, r := range results {
+			names = append(names, r.Name)
+		}
+		return names, conflictf("namespace %s still has %d releases, uninstall them first", namespace, len(results))
+	}
+	return nil, clientset.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/action"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryActionConfig 用内存存储release的action配置，namespace为空时可以列出所有命名空间
+func memoryActionConfig(t *testing.T, namespace string, releases ...*release.Release) *action.Configuration {
+	mem := driver.NewMemory()
+	for _, r := range releases {
+		if err := mem.Create(r.Name+".v1", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem.SetNamespace(namespace)
+	return &action.Configuration{
+		Releases:   storage.Init(mem),
+		KubeClient: &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Log:        func(string, ...interface{}) {},
+	}
+}
+
+func testRelease(name, namespace string, status release.Status) *release.Release {
+	r := deployedRelease(name, "chart-00", "1.0.1", "2.1.0")
+	r.Namespace = namespace
+	r.Info.Status = status
+	return r
+}
+
+func TestProtectedNamespace(t *testing.T) {
+	old := helmConfig
+	defer func() { helmConfig = old }()
+	helmConfig = &HelmConfig{ReleaseLock: &releaseLockConfig{Namespace: "helm-proxy"}}
+
+	for _, ns := range []string{"kube-system", "kube-public", "kube-node-lease", "default", "helm-proxy", settings.Namespace()} {
+		if !protectedNamespace(ns) {
+			t.Errorf("namespace %s is not protected", ns)
+		}
+	}
+	if protectedNamespace("team-a") {
+		t.Error("namespace team-a is protected")
+	}
+
+	// 受保护的命名空间在查询release之前就被拒绝
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+	for _, ns := range []string{"kube-system", "helm-proxy"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v2/namespaces/"+ns, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("DELETE namespace %s: status %d %s, want %d", ns, w.Code, w.Body.String(), http.StatusForbidden)
+		}
+	}
+}
+
+func TestRemoveNamespaceWithReleases(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	)
+	releases := []*release.Release{
+		testRelease("web", "team-a", release.StatusDeployed),
+		testRelease("db", "team-a", release.StatusFailed),
+		testRelease("cache", "team-b", release.StatusUninstalled),
+	}
+
+	// 任意状态的release都阻止删除，包括已卸载但保留历史的
+	for ns, want := range map[string]int{"team-a": 2, "team-b": 1} {
+		names, err := removeNamespace(clientset, memoryActionConfig(t, ns, releases...), ns)
+		if errorStatus(err) != http.StatusConflict || len(names) != want {
+			t.Errorf("%s: got %v %v, want conflict with %d releases", ns, names, err, want)
+		}
+		if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), ns, metav1.GetOptions{}); err != nil {
+			t.Errorf("%s is deleted although it has releases: %v", ns, err)
+		}
+	}
+
+	if _, err := removeNamespace(clientset, memoryActionConfig(t, "team-b", releases[:2]...), "team-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), "team-b", metav1.GetOptions{}); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("team-b is not deleted: %v", err)
+	}
+}
+
+func TestNewNamespaceLabelsAndQuotas(t *testing.T) {
+	old := helmConfig
+	defer func() { helmConfig = old }()
+	helmConfig = &HelmConfig{QuotaTemplates: map[string]*quotaTemplate{
+		"small": {Hard: map[string]string{"requests.cpu": "2", "pods": "20"}, Scopes: []string{"NotTerminating"}},
+		"bad":   {Hard: map[string]string{"pods": "many"}},
+	}}
+	clientset := fake.NewSimpleClientset()
+
+	ns, err := newNamespace(clientset, &namespaceOptions{
+		Name:        "team-a",
+		Labels:      map[string]string{"team": "a"},
+		Annotations: map[string]string{"owner": "team-a@example.com"},
+		Quotas:      []string{"small"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels["team"] != "a" || ns.Annotations["owner"] != "team-a@example.com" {
+		t.Errorf("namespace %+v does not have the labels and annotations", ns)
+	}
+	quota, err := clientset.CoreV1().ResourceQuotas("team-a").Get(context.Background(), "small", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu, pods := quota.Spec.Hard[corev1.ResourceRequestsCPU], quota.Spec.Hard[corev1.ResourcePods]
+	if cpu.String() != "2" || pods.String() != "20" || len(quota.Spec.Scopes) != 1 || quota.Spec.Scopes[0] != corev1.ResourceQuotaScopeNotTerminating {
+		t.Errorf("unexpected quota spec %+v", quota.Spec)
+	}
+
+	// 配额模板不存在或无效时不创建命名空间
+	for _, template := range []string{"missing", "bad"} {
+		if _, err := newNamespace(clientset, &namespaceOptions{Name: "team-" + template, Quotas: []string{template}}); err == nil {
+			t.Errorf("quota template %s is accepted", template)
+		}
+		if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), "team-"+template, metav1.GetOptions{}); err == nil {
+			t.Errorf("namespace is created with quota template %s", template)
+		}
+	}
+}
+
+func TestListNamespacesHasReleases(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+	)
+	counts, err := releaseCounts(memoryActionConfig(t, "",
+		testRelease("web", "team-a", release.StatusDeployed),
+		testRelease("db", "team-a", release.StatusFailed),
+		testRelease("cache", "team-b", release.StatusSuperseded),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces, err := namespaceList(clientset, counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hasReleases string
+		want        []string
+	}{
+		{"", []string{"empty", "team-a", "team-b"}},
+		{"true", []string{"team-a", "team-b"}},
+		{"false", []string{"empty"}},
+	}
+	for _, tt := range tests {
+		filtered, err := filterNamespaces(namespaces, tt.hasReleases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ns := range filtered {
+			names = append(names, ns.Name)
+		}
+		if len(names) != len(tt.want) {
+			t.Errorf("has_releases=%q: got %v, want %v", tt.hasReleases, names, tt.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.want[i] {
+				t.Errorf("has_releases=%q: got %v, want %v", tt.hasReleases, names, tt.want)
+				break
+			}
+		}
+	}
+	if namespaces[1].Releases != 2 || namespaces[2].Releases != 1 {
+		t.Errorf("release counts %+v, want 2 in team-a and 1 in team-b", namespaces)
+	}
+	if _, err := filterNamespaces(namespaces, "maybe"); errorStatus(err) != http.StatusBadRequest {
+		t.Errorf("bad has_releases: %v", err)
+	}
+}
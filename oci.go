@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	orascontent "github.com/deislabs/oras/pkg/content"
+	"github.com/deislabs/oras/pkg/oras"
+	"github.com/golang/glog"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	ociScheme = "oci://"
+
+	// helm chart在OCI镜像仓库中的media type
+	helmChartConfigMediaType       = "application/vnd.cncf.helm.config.v1+json"
+	helmChartContentLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// helm 3.3及之前版本push使用的layer media type
+	helmChartLegacyLayerMediaType = "application/tar+gzip"
+)
+
+// OCI镜像仓库的登录信息
+type registryConfig struct {
+	Host                  string `yaml:"host" json:"host"`
+	Username              string `yaml:"username" json:"username"`
+	Password              string `yaml:"password" json:"password"`
+	CertFile              string `yaml:"certFile" json:"certFile"`
+	KeyFile               string `yaml:"keyFile" json:"keyFile"`
+	CAFile                string `yaml:"caFile" json:"caFile"`
+	InsecureSkipTLSverify bool   `yaml:"insecure_skip_tls_verify" json:"insecure_skip_tls_verify"`
+	PlainHTTP             bool   `yaml:"plainHttp" json:"plainHttp"`
+
+	UsernameFrom *credentialSource `yaml:"usernameFrom" json:"usernameFrom,omitempty"`
+	PasswordFrom *credentialSource `yaml:"passwordFrom" json:"passwordFrom,omitempty"`
+}
+
+func (r *registryConfig) resolveCredentials() error {
+	if err := resolveCredential(&r.Username, r.UsernameFrom); err != nil {
+		return errors.Wrapf(err, "failed to resolve username of registry %s", r.Host)
+	}
+	if err := resolveCredential(&r.Password, r.PasswordFrom); err != nil {
+		return errors.Wrapf(err, "failed to resolve password of registry %s", r.Host)
+	}
+	return nil
+}
+
+// oci://host/path/name:tag
+type ociReference struct {
+	Host string
+	Repo string // path/name, without host
+	Tag  string
+}
+
+func (r *ociReference) String() string {
+	return r.Host + "/" + r.Repo + ":" + r.Tag
+}
+
+func isOCIReference(ref string) bool {
+	return strings.HasPrefix(ref, ociScheme)
+}
+
+// parseOCIReference 解析oci://开头的chart引用，引用中没有tag时使用version
+func parseOCIReference(ref, version string) (*ociReference, error) {
+	s := strings.TrimPrefix(ref, ociScheme)
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return nil, errors.Errorf("invalid oci reference %q", ref)
+	}
+	r := &ociReference{Host: s[:i], Repo: s[i+1:]}
+	if j := strings.LastIndex(r.Repo, ":"); j > 0 {
+		r.Tag = r.Repo[j+1:]
+		r.Repo = r.Repo[:j]
+	}
+	if r.Tag == "" {
+		r.Tag = version
+	}
+	if r.Tag == "" {
+		return nil, errors.Errorf("oci reference %q requires a tag or chart version", ref)
+	}
+	// semver的build metadata中的"+"在OCI tag中不合法
+	r.Tag = strings.Replace(r.Tag, "+", "_", -1)
+	return r, nil
+}
+
+func findRegistryConfig(host string) *registryConfig {
+	for _, r := range currentConfig().Registries {
+		if r.Host == host {
+			return r
+		}
+	}
+	return &registryConfig{Host: host}
+}
+
+func newTLSConfig(certFile, keyFile, caFile string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't load key pair from cert %s and key %s", certFile, keyFile)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read CA file %s", caFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("failed to append certificates from file: %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// ociResolver 根据镜像仓库配置生成resolver，username/password非空时覆盖配置中的登录信息
+func ociResolver(host, username, password string) (remotes.Resolver, error) {
+	rc := findRegistryConfig(host)
+	if username == "" {
+		username, password = rc.Username, rc.Password
+	}
+
+	tlsConfig, err := newTLSConfig(rc.CertFile, rc.KeyFile, rc.CAFile, rc.InsecureSkipTLSverify)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport}
+
+	authorizer := docker.NewDockerAuthorizer(
+		docker.WithAuthClient(client),
+		docker.WithAuthCreds(func(string) (string, string, error) {
+			return username, password, nil
+		}),
+	)
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(
+			docker.WithClient(client),
+			docker.WithAuthorizer(authorizer),
+			docker.WithPlainHTTP(func(string) (bool, error) {
+				return rc.PlainHTTP, nil
+			}),
+		),
+	}), nil
+}
+
+// pullOCIChart 从OCI镜像仓库拉取chart，返回本地缓存的chart包路径
+func pullOCIChart(ref, version string) (string, error) {
+	r, err := parseOCIReference(ref, version)
+	if err != nil {
+		return "", err
+	}
+	resolver, err := ociResolver(r.Host, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	store := orascontent.NewMemoryStore()
+	_, layers, err := oras.Pull(context.Background(), resolver, r.String(), store,
+		oras.WithPullEmptyNameAllowed(),
+		oras.WithAllowedMediaTypes([]string{
+			helmChartConfigMediaType,
+			helmChartContentLayerMediaType,
+			helmChartLegacyLayerMediaType,
+		}),
+	)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to pull %s", ref)
+	}
+
+	var data []byte
+	for _, l := range layers {
+		if l.MediaType == helmChartContentLayerMediaType || l.MediaType == helmChartLegacyLayerMediaType {
+			_, data, _ = store.Get(l)
+			break
+		}
+	}
+	if data == nil {
+		return "", errors.Errorf("%s does not contain a helm chart", ref)
+	}
+
+	dir := filepath.Join(settings.RepositoryCache, "oci", strings.Replace(r.Host, ":", "_", -1), filepath.FromSlash(r.Repo))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", filepath.Base(r.Repo), r.Tag))
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		return "", err
+	}
+	glog.Infof("pulled %s to %s", r.String(), name)
+	return name, nil
+}
+
+// pushOCIChart 将chart包推送到OCI镜像仓库，tag为chart版本
+func pushOCIChart(chartPackagePath string, r *repo.Entry) error {
+	data, err := ioutil.ReadFile(chartPackagePath)
+	if err != nil {
+		return err
+	}
+	chrt, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	ref, err := parseOCIReference(strings.TrimSuffix(r.URL, "/")+"/"+chrt.Name(), chrt.Metadata.Version)
+	if err != nil {
+		return err
+	}
+	configData, err := json.Marshal(chrt.Metadata)
+	if err != nil {
+		return err
+	}
+
+	store := orascontent.NewMemoryStore()
+	layer := store.Add("", helmChartContentLayerMediaType, data)
+	config := store.Add("", helmChartConfigMediaType, configData)
+
+	resolver, err := ociResolver(ref.Host, r.Username, r.Password)
+	if err != nil {
+		return err
+	}
+	_, err = oras.Push(context.Background(), resolver, ref.String(), store, []ocispec.Descriptor{layer},
+		oras.WithConfig(config), oras.WithNameValidation(nil))
+	if err != nil {
+		return errors.Wrapf(err, "failed to push %s", ref.String())
+	}
+	glog.Infof("pushed %s to %s", filepath.Base(chartPackagePath), ref.String())
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// fakeRegistry 只实现push和pull用到的distribution API
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte // tag或digest
+	types     map[string]string
+	uploads   int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := r.URL.Path
+	if p == "/v2/" || p == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	switch {
+	case strings.Contains(p, "/blobs/uploads/"):
+		if r.Method == http.MethodPost {
+			f.uploads++
+			w.Header().Set("Location", fmt.Sprintf("%s%d", p, f.uploads))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		dgst := r.URL.Query().Get("digest")
+		f.blobs[dgst] = body
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		dgst := p[strings.LastIndex(p, "/")+1:]
+		f.serve(w, r, f.blobs[dgst], dgst, "application/octet-stream")
+	case strings.Contains(p, "/manifests/"):
+		ref := p[strings.LastIndex(p, "/")+1:]
+		if r.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(r.Body)
+			dgst := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+			for _, k := range []string{ref, dgst} {
+				f.manifests[k] = body
+				f.types[k] = r.Header.Get("Content-Type")
+			}
+			w.Header().Set("Docker-Content-Digest", dgst)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		body := f.manifests[ref]
+		f.serve(w, r, body, fmt.Sprintf("sha256:%x", sha256.Sum256(body)), f.types[ref])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, r *http.Request, body []byte, dgst, mediaType string) {
+	if body == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", dgst)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+func TestPushAndPullOCIChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := httptest.NewServer(newFakeRegistry())
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	oldConfig, oldCache := helmConfig, settings.RepositoryCache
+	defer func() { helmConfig, settings.RepositoryCache = oldConfig, oldCache }()
+	helmConfig = &HelmConfig{Registries: []*registryConfig{{Host: host, PlainHTTP: true}}}
+	settings.RepositoryCache = filepath.Join(dir, "cache")
+
+	chartDir, err := chartutil.Create("demo", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chrt, err := loader.LoadDir(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := chartutil.Save(chrt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pushOCIChart(pkg, &repo.Entry{Name: "oci", URL: ociScheme + host + "/charts/"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	pulled, err := pullOCIChart(ociScheme+host+"/charts/demo", chrt.Metadata.Version)
+	if err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	got, err := loader.Load(pulled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name() != "demo" || got.Metadata.Version != chrt.Metadata.Version {
+		t.Errorf("pulled chart %s-%s, want demo-%s", got.Name(), got.Metadata.Version, chrt.Metadata.Version)
+	}
+
+	if _, err := pullOCIChart(ociScheme+host+"/charts/missing", "1.0.0"); err == nil {
+		t.Error("pulling a missing chart should fail")
+	}
+}
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref, version, want string
+		wantErr            bool
+	}{
+		{ref: "oci://example.com/charts/demo:1.0.0", want: "example.com/charts/demo:1.0.0"},
+		{ref: "oci://example.com/charts/demo", version: "1.0.0+build", want: "example.com/charts/demo:1.0.0_build"},
+		{ref: "oci://example.com:5000/demo", version: "0.1.0", want: "example.com:5000/demo:0.1.0"},
+		{ref: "oci://example.com/charts/demo", wantErr: true},
+		{ref: "oci://example.com", version: "1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := parseOCIReference(tt.ref, tt.version)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseOCIReference(%q, %q) should fail", tt.ref, tt.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseOCIReference(%q, %q): %v", tt.ref, tt.version, err)
+			continue
+		}
+		if r.String() != tt.want {
+			t.Errorf("parseOCIReference(%q, %q) = %s, want %s", tt.ref, tt.version, r.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cm "github.com/chartmuseum/helm-push/pkg/chartmuseum"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// chart签名与校验配置
+type provenanceConfig struct {
+	Verify  bool   `yaml:"verify" json:"verify"`   //安装、升级、查看chart时是否要求校验.prov签名，可被仓库的verify覆盖
+	Keyring string `yaml:"keyring" json:"keyring"` //校验用的公钥keyring
+
+	Sign           bool              `yaml:"sign" json:"sign"`                     //新建chart上传时是否签名
+	Key            string            `yaml:"key" json:"key"`                       //签名用的key名称
+	SigningKeyring string            `yaml:"signingKeyring" json:"signingKeyring"` //包含私钥的keyring
+	PassphraseFrom *credentialSource `yaml:"passphraseFrom" json:"passphraseFrom"` //私钥的密码，私钥未加密时不需要
+
+	passphrase string
+}
+
+func provenanceSettings() *provenanceConfig {
+	return currentConfig().provenance()
+}
+
+func (p *provenanceConfig) resolveCredentials() error {
+	if p.PassphraseFrom == nil {
+		return nil
+	}
+	if err := resolveCredential(&p.passphrase, p.PassphraseFrom); err != nil {
+		return errors.Wrap(err, "failed to resolve passphrase of signing key")
+	}
+	return nil
+}
+
+// applyProvenance 按全局和仓库配置决定是否校验chart签名，需要校验时设置opts的Verify和Keyring
+func applyProvenance(opts *action.ChartPathOptions, name string) error {
+	pc := provenanceSettings()
+	verify, keyring := pc.Verify, pc.Keyring
+	if rc := chartRepository(opts, name); rc != nil {
+		if rc.Verify != nil {
+			verify = *rc.Verify
+		}
+		if rc.Keyring != "" {
+			keyring = rc.Keyring
+		}
+	}
+	if !verify {
+		return nil
+	}
+
+	if isOCIReference(name) {
+		return errors.Errorf("provenance verification of %s is required, but it is not supported for oci charts", name)
+	}
+	if keyring == "" {
+		return errors.Errorf("provenance verification of %s is required, but no keyring is configured", name)
+	}
+	opts.Verify = true
+	opts.Keyring = keyring
+	return nil
+}
+
+// chartRepository chart所属的仓库，本地chart返回nil
+func chartRepository(opts *action.ChartPathOptions, name string) *repoConfig {
+	for _, rc := range repositories.all() {
+		if opts.RepoURL != "" {
+			if strings.TrimSuffix(rc.URL, "/") == strings.TrimSuffix(opts.RepoURL, "/") {
+				return rc
+			}
+			continue
+		}
+		if strings.HasPrefix(name, rc.Name+"/") || (isOCIReference(rc.URL) && strings.HasPrefix(name, strings.TrimSuffix(rc.URL, "/")+"/")) {
+			if _, err := os.Stat(name); err == nil {
+				return nil
+			}
+			return rc
+		}
+	}
+	return nil
+}
+
+// signChartPackage 生成chart包的.prov文件，未开启签名时返回空路径
+func signChartPackage(chartPackagePath string) (string, error) {
+	pc := provenanceSettings()
+	if !pc.Sign {
+		return "", nil
+	}
+	signer, err := provenance.NewFromKeyring(pc.SigningKeyring, pc.Key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to load signing key")
+	}
+	err = signer.DecryptKey(func(name string) ([]byte, error) {
+		if pc.passphrase == "" {
+			return nil, errors.Errorf("signing key %q is encrypted, but no passphrase is configured", name)
+		}
+		return []byte(pc.passphrase), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sig, err := signer.ClearSign(chartPackagePath)
+	if err != nil {
+		return "", err
+	}
+	provPath := chartPackagePath + ".prov"
+	return provPath, ioutil.WriteFile(provPath, []byte(sig), 0644)
+}
+
+// uploadProvenanceFile 上传.prov文件到ChartMuseum(POST /api/prov)，地址规则与helm-push上传chart一致
+func uploadProvenanceFile(client *cm.Client, r *repo.Entry, p *pusher, repoURL, provPath string) error {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(p.contextPath, "api", strings.TrimPrefix(u.Path, p.contextPath), "prov")
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("prov", filepath.Base(provPath))
+	if err != nil {
+		return err
+	}
+	fd, err := os.Open(provPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := io.Copy(fw, fd); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	if p.forceUpload {
+		req.URL.RawQuery = "force"
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if p.accessToken != "" {
+		if p.authHeader != "" {
+			req.Header.Set(p.authHeader, p.accessToken)
+		} else {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.accessToken))
+		}
+	} else if r.Username != "" && r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	glog.Infof("pushing %s to %s", filepath.Base(provPath), p.repoName)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return handlePushResponse(resp)
+}
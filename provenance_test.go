@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const testSigningKey = "helm-proxy test <test@example.com>"
+
+// writeTestKeyrings 生成不加密的签名key，返回包含私钥和只包含公钥的keyring路径
+func writeTestKeyrings(t *testing.T, dir string) (secring, pubring string) {
+	e, err := openpgp.NewEntity("helm-proxy test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secring, pubring = filepath.Join(dir, "secring.gpg"), filepath.Join(dir, "pubring.gpg")
+	for path, serialize := range map[string]func(*os.File) error{
+		secring: func(f *os.File) error { return e.SerializePrivate(f, nil) },
+		pubring: func(f *os.File) error { return e.Serialize(f) },
+	} {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := serialize(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return secring, pubring
+}
+
+// packageTestChart 在dir下创建并打包demo chart
+func packageTestChart(t *testing.T, dir string) string {
+	chartDir, err := chartutil.Create("demo", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chrt, err := loader.LoadDir(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := chartutil.Save(chrt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func withProvenanceConfig(pc *provenanceConfig) func() {
+	old := helmConfig
+	helmConfig = &HelmConfig{Provenance: pc}
+	return func() { helmConfig = old }
+}
+
+func TestSignChartPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secring, pubring := writeTestKeyrings(t, dir)
+	pkg := packageTestChart(t, dir)
+
+	restore := withProvenanceConfig(&provenanceConfig{})
+	if provPath, err := signChartPackage(pkg); err != nil || provPath != "" {
+		t.Fatalf("signing is disabled, got %q, %v", provPath, err)
+	}
+	restore()
+
+	defer withProvenanceConfig(&provenanceConfig{Sign: true, Key: testSigningKey, SigningKeyring: secring})()
+	provPath, err := signChartPackage(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provPath != pkg+".prov" {
+		t.Errorf("provenance file = %s, want %s.prov", provPath, pkg)
+	}
+	verifier, err := provenance.NewFromKeyring(pubring, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(pkg, provPath); err != nil {
+		t.Errorf("signed chart cannot be verified with the public keyring: %v", err)
+	}
+}
+
+func TestApplyProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secring, pubring := writeTestKeyrings(t, dir)
+
+	// 用签名后的chart搭一个chart仓库，tampered为true时返回被篡改的.prov
+	repoDir := filepath.Join(dir, "repo")
+	if err := os.Mkdir(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pkg := packageTestChart(t, repoDir)
+	restore := withProvenanceConfig(&provenanceConfig{Sign: true, Key: testSigningKey, SigningKeyring: secring})
+	provPath, err := signChartPackage(pkg)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov, err := ioutil.ReadFile(provPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedProv := regexp.MustCompile(`sha256:[0-9a-f]{64}`).ReplaceAll(prov, []byte("sha256:0000000000000000000000000000000000000000000000000000000000000000"))
+	tampered := false
+	files := http.FileServer(http.Dir(repoDir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tampered && filepath.Ext(r.URL.Path) == ".prov" {
+			w.Write(tamperedProv)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	oldConfig, oldCache, oldRepos := settings.RepositoryConfig, settings.RepositoryCache, repositories.repos
+	defer func() {
+		settings.RepositoryConfig, settings.RepositoryCache, repositories.repos = oldConfig, oldCache, oldRepos
+	}()
+	settings.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	settings.RepositoryCache = filepath.Join(dir, "cache")
+	index, err := repo.IndexDirectory(repoDir, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(settings.RepositoryCache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.WriteFile(filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile("signed")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		verify     bool
+		repoVerify *bool
+		tampered   bool
+		wantVerify bool
+		wantErr    bool
+	}{
+		{name: "global verify, valid", verify: true, wantVerify: true},
+		{name: "global verify, tampered", verify: true, tampered: true, wantVerify: true, wantErr: true},
+		{name: "repo verify, valid", repoVerify: &yes, wantVerify: true},
+		{name: "repo verify, tampered", repoVerify: &yes, tampered: true, wantVerify: true, wantErr: true},
+		{name: "repo disables global verify, tampered", verify: true, repoVerify: &no, tampered: true},
+		{name: "no verify, tampered", tampered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &repoConfig{Entry: repo.Entry{Name: "signed", URL: server.URL}, Verify: tt.repoVerify}
+			repositories.repos = []*repoConfig{rc}
+			if err := writeRepositoryFile(repositories.repos); err != nil {
+				t.Fatal(err)
+			}
+			defer withProvenanceConfig(&provenanceConfig{Verify: tt.verify, Keyring: pubring})()
+			tampered = tt.tampered
+
+			opts := &action.ChartPathOptions{}
+			_, err := locateChart(opts, "signed/demo")
+			if opts.Verify != tt.wantVerify {
+				t.Errorf("opts.Verify = %v, want %v", opts.Verify, tt.wantVerify)
+			}
+			if tt.wantVerify && opts.Keyring != pubring {
+				t.Errorf("opts.Keyring = %q, want %q", opts.Keyring, pubring)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("locateChart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 仓库单独配置的keyring优先于全局keyring
+	repositories.repos = []*repoConfig{{Entry: repo.Entry{Name: "signed", URL: server.URL}, Keyring: secring}}
+	defer withProvenanceConfig(&provenanceConfig{Verify: true, Keyring: pubring})()
+	opts := &action.ChartPathOptions{}
+	if err := applyProvenance(opts, "signed/demo"); err != nil || opts.Keyring != secring {
+		t.Errorf("repository keyring is not used: %q, %v", opts.Keyring, err)
+	}
+}
+
+func inlineInstallRequest(t *testing.T, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for field, path := range files {
+		fw, err := w.CreateFormFile(field, filepath.Base(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(b)
+	}
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/namespaces/default/releases/demo", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestInlineChartProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secring, pubring := writeTestKeyrings(t, dir)
+	pkg := packageTestChart(t, dir)
+	restore := withProvenanceConfig(&provenanceConfig{Sign: true, Key: testSigningKey, SigningKeyring: secring})
+	provPath, err := signChartPackage(pkg)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherProv := filepath.Join(dir, "other", filepath.Base(provPath))
+	os.Mkdir(filepath.Dir(otherProv), 0755)
+	prov, _ := ioutil.ReadFile(provPath)
+	ioutil.WriteFile(otherProv, bytes.Replace(prov, []byte("name: demo"), []byte("name: evil"), 1), 0644)
+
+	oldRepos := repositories.repos
+	defer func() { repositories.repos = oldRepos }()
+	repositories.repos = nil
+	defer withProvenanceConfig(&provenanceConfig{Verify: true, Keyring: pubring})()
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr bool
+	}{
+		{name: "chart view", req: httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"chart":{"metadata":{"name":"demo","version":"0.1.0"}}}`)), wantErr: true},
+		{name: "archive without prov", req: inlineInstallRequest(t, map[string]string{"chart": pkg}), wantErr: true},
+		{name: "archive with tampered prov", req: inlineInstallRequest(t, map[string]string{"chart": pkg, "prov": otherProv}), wantErr: true},
+		{name: "archive with prov", req: inlineInstallRequest(t, map[string]string{"chart": pkg, "prov": provPath})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.req.Header.Get("Content-Type") == "" {
+				tt.req.Header.Set("Content-Type", "application/json")
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.req
+			var options inlineInstallOptions
+			chrt, err := bindInstallOptions(c, &options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bindInstallOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (chrt == nil || chrt.Name() != "demo") {
+				t.Errorf("unexpected chart %v", chrt)
+			}
+			if err != nil && (errorStatus(err) != http.StatusBadRequest || !strings.Contains(err.Error(), "provenance verification")) {
+				t.Errorf("unexpected error %v with status %d", err, errorStatus(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultConcurrencyRetryAfter = 5 * time.Second
+	rateLimiterIdleTimeout       = 10 * time.Minute
+	rateLimiterMaxIdentities     = 10000
+)
+
+// 限流配置，未配置的项不限制
+type rateLimitConfig struct {
+	Rate       float64                   `yaml:"rate" json:"rate"`             //每个用户(或客户端IP)每秒请求数
+	Burst      int                       `yaml:"burst" json:"burst"`           //令牌桶容量，默认与rate相同
+	Identities map[string]*rateLimitRule `yaml:"identities" json:"identities"` //单独设置某些用户的限制
+
+	MaxConcurrent             int      `yaml:"maxConcurrent" json:"maxConcurrent"`                         //全局同时进行的变更操作数
+	MaxConcurrentPerNamespace int      `yaml:"maxConcurrentPerNamespace" json:"maxConcurrentPerNamespace"` //每个命名空间同时进行的变更操作数
+	RetryAfter                duration `yaml:"retryAfter" json:"retryAfter"`                               //超过并发限制时建议的重试间隔
+}
+
+type rateLimitRule struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+func rateLimitSettings() *rateLimitConfig {
+	lc := currentConfig().RateLimit
+	if lc == nil {
+		lc = &rateLimitConfig{}
+	}
+	return lc
+}
+
+// rule 用户的限制，rate为0表示不限制
+func (lc *rateLimitConfig) rule(identity string) rateLimitRule {
+	r := rateLimitRule{Rate: lc.Rate, Burst: lc.Burst}
+	if o, ok := lc.Identities[identity]; ok {
+		r = *o
+	}
+	if r.Burst <= 0 {
+		r.Burst = int(math.Ceil(r.Rate))
+	}
+	return r
+}
+
+func (lc *rateLimitConfig) retryAfter() time.Duration {
+	if lc.RetryAfter > 0 {
+		return time.Duration(lc.RetryAfter)
+	}
+	return defaultConcurrencyRetryAfter
+}
+
+type identityLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter 每个用户一个令牌桶，长时间没有请求的定期清理，数量超过上限时淘汰最久没有请求的
+type rateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*identityLimiter
+	swept    time.Time
+}
+
+var rateLimits = &rateLimiter{limiters: map[string]*identityLimiter{}}
+
+// reserve 取一个令牌，没有可用令牌时返回需要等待的时间
+func (l *rateLimiter) reserve(identity string, rule rateLimitRule) time.Duration {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimiterIdleTimeout {
+		for k, e := range l.limiters {
+			if now.Sub(e.lastSeen) > rateLimiterIdleTimeout {
+				delete(l.limiters, k)
+			}
+		}
+		l.swept = now
+	}
+
+	e, ok := l.limiters[identity]
+	if !ok {
+		if len(l.limiters) >= rateLimiterMaxIdentities {
+			l.evictOldest()
+		}
+		e = &identityLimiter{limiter: rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst)}
+		l.limiters[identity] = e
+	}
+	e.lastSeen = now
+	// 配置重新加载后更新限制
+	if e.limiter.Limit() != rate.Limit(rule.Rate) {
+		e.limiter.SetLimitAt(now, rate.Limit(rule.Rate))
+	}
+	if e.limiter.Burst() != rule.Burst {
+		e.limiter.SetBurstAt(now, rule.Burst)
+	}
+
+	r := e.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Second
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+func (l *rateLimiter) evictOldest() {
+	oldest := ""
+	var seen time.Time
+	for k, e := range l.limiters {
+		if oldest == "" || e.lastSeen.Before(seen) {
+			oldest, seen = k, e.lastSeen
+		}
+	}
+	delete(l.limiters, oldest)
+}
+
+// rateLimit 按客户端证书校验过的用户(没有时按客户端IP)限制请求速率，超过时返回429；
+// X-Remote-User请求头可以随意设置，不用来区分用户，X-Forwarded-For只信任trustedProxies中的代理设置的
+func rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := c.GetString(ctxUserKey)
+		if identity == "" {
+			identity = clientIP(c)
+		}
+		rule := rateLimitSettings().rule(identity)
+		if rule.Rate <= 0 {
+			c.Next()
+			return
+		}
+		if delay := rateLimits.reserve(identity, rule); delay > 0 {
+			rateLimitedTotal.WithLabelValues("rate").Inc()
+			tooManyRequests(c, delay, errors.Errorf("rate limit exceeded for %s", identity))
+			return
+		}
+		c.Next()
+	}
+}
+
+// concurrencyLimiter 同时进行的变更操作数，全局和按命名空间统计
+type concurrencyLimiter struct {
+	mu          sync.Mutex
+	total       int
+	byNamespace map[string]int
+}
+
+var concurrencyLimits = &concurrencyLimiter{byNamespace: map[string]int{}}
+
+func (l *concurrencyLimiter) acquire(namespace string, lc *rateLimitConfig) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lc.MaxConcurrent > 0 && l.total >= lc.MaxConcurrent {
+		return nil, errors.Errorf("too many concurrent operations (max %d)", lc.MaxConcurrent)
+	}
+	if namespace != "" && lc.MaxConcurrentPerNamespace > 0 && l.byNamespace[namespace] >= lc.MaxConcurrentPerNamespace {
+		return nil, errors.Errorf("too many concurrent operations in namespace %s (max %d)", namespace, lc.MaxConcurrentPerNamespace)
+	}
+	l.total++
+	if namespace != "" {
+		l.byNamespace[namespace]++
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.total--
+		if namespace != "" {
+			if l.byNamespace[namespace]--; l.byNamespace[namespace] <= 0 {
+				delete(l.byNamespace, namespace)
+			}
+		}
+	}, nil
+}
+
+// concurrencyLimit 限制同时进行的变更操作数，超过时返回429
+func concurrencyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		lc := rateLimitSettings()
+		done, err := concurrencyLimits.acquire(c.Param("namespace"), lc)
+		if err != nil {
+			rateLimitedTotal.WithLabelValues("concurrency").Inc()
+			tooManyRequests(c, lc.retryAfter(), err)
+			return
+		}
+		defer done()
+		c.Next()
+	}
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration, err error) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respErrStatus(c, http.StatusTooManyRequests, err, nil)
+	c.Abort()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitIgnoresRemoteUserHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old, oldLimits := helmConfig, rateLimits
+	defer func() { helmConfig, rateLimits = old, oldLimits }()
+	helmConfig = &HelmConfig{RateLimit: &rateLimitConfig{Rate: 1, Burst: 1}}
+	rateLimits = &rateLimiter{limiters: map[string]*identityLimiter{}}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-Cert-User"); user != "" {
+			c.Set(ctxUserKey, user)
+		}
+	}, rateLimit())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(header, value string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(header, value)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 换X-Remote-User不能绕过按客户端IP的限流
+	if code := get("X-Remote-User", "alice"); code != http.StatusOK {
+		t.Fatalf("first request: %d", code)
+	}
+	if code := get("X-Remote-User", "bob"); code != http.StatusTooManyRequests {
+		t.Errorf("request with another X-Remote-User: %d, want 429", code)
+	}
+	// 客户端证书校验过的用户单独限流
+	if code := get("X-Test-Cert-User", "carol"); code != http.StatusOK {
+		t.Errorf("request of a verified user: %d, want 200", code)
+	}
+}
+
+func TestRateLimiterCapsIdentities(t *testing.T) {
+	l := &rateLimiter{limiters: map[string]*identityLimiter{}, swept: time.Now()}
+	rule := rateLimitRule{Rate: 1, Burst: 1}
+	l.reserve("first", rule)
+	for i := 0; i < rateLimiterMaxIdentities; i++ {
+		l.reserve(fmt.Sprintf("client-%d", i), rule)
+	}
+	if len(l.limiters) != rateLimiterMaxIdentities {
+		t.Errorf("%d limiters, want at most %d", len(l.limiters), rateLimiterMaxIdentities)
+	}
+	if _, ok := l.limiters["first"]; ok {
+		t.Error("the least recently seen limiter is not evicted")
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old, oldLimits := helmConfig, rateLimits
+	defer func() { helmConfig, rateLimits = old, oldLimits }()
+	helmConfig = &HelmConfig{RateLimit: &rateLimitConfig{Rate: 1, Burst: 1}}
+
+	get := func(router *gin.Engine, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	newRouter := func(trustedProxies ...string) *gin.Engine {
+		rateLimits = &rateLimiter{limiters: map[string]*identityLimiter{}}
+		router, err := newEngine(trustedProxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.Use(rateLimit())
+		router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, clientIP(c)) })
+		return router
+	}
+
+	// 没有配置信任的代理时，伪造X-Forwarded-For不能绕过限流
+	router := newRouter()
+	if w := get(router, "192.0.2.1:1234", "198.51.100.1"); w.Code != http.StatusOK || w.Body.String() != "192.0.2.1" {
+		t.Fatalf("first request: %d %q", w.Code, w.Body.String())
+	}
+	w := get(router, "192.0.2.1:1234", "198.51.100.2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("request with a spoofed X-Forwarded-For: %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After %q, want 1", got)
+	}
+
+	// 来自信任的代理时按X-Forwarded-For中的客户端限流
+	router = newRouter("192.0.2.0/24")
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if w := get(router, "192.0.2.1:1234", client); w.Code != http.StatusOK || w.Body.String() != client {
+			t.Errorf("client %s behind a trusted proxy: %d %q", client, w.Code, w.Body.String())
+		}
+	}
+	if w := get(router, "192.0.2.1:1234", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request of the same client behind a trusted proxy: %d, want 429", w.Code)
+	}
+	// 不信任的地址设置的X-Forwarded-For被忽略
+	if w := get(router, "203.0.113.1:1234", "198.51.100.3"); w.Code != http.StatusOK || w.Body.String() != "203.0.113.1" {
+		t.Errorf("request from an untrusted address: %d %q", w.Code, w.Body.String())
+	}
+
+	if _, err := newEngine([]string{"not-an-ip"}); err == nil {
+		t.Error("invalid trusted proxy is accepted")
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old, oldLimits := helmConfig, concurrencyLimits
+	defer func() { helmConfig, concurrencyLimits = old, oldLimits }()
+	helmConfig = &HelmConfig{RateLimit: &rateLimitConfig{MaxConcurrent: 2, MaxConcurrentPerNamespace: 1, RetryAfter: duration(3 * time.Second)}}
+	concurrencyLimits = &concurrencyLimiter{byNamespace: map[string]int{}}
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(concurrencyLimit())
+	handler := func(c *gin.Context) {
+		if c.Query("block") != "" {
+			started <- struct{}{}
+			<-finish
+		}
+		c.Status(http.StatusOK)
+	}
+	router.GET("/namespaces/:namespace/releases", handler)
+	router.POST("/namespaces/:namespace/releases", handler)
+	serve := func(method, namespace, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/namespaces/"+namespace+"/releases"+query, nil))
+		return w
+	}
+
+	done := make(chan int)
+	go func() { done <- serve(http.MethodPost, "team-a", "?block=1").Code }()
+	<-started
+
+	// 同一命名空间超过并发限制
+	w := serve(http.MethodPost, "team-a", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("second operation in team-a: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// 查询请求不受限制
+	if w := serve(http.MethodGet, "team-a", ""); w.Code != http.StatusOK {
+		t.Errorf("get in team-a: %d", w.Code)
+	}
+
+	go func() { done <- serve(http.MethodPost, "team-b", "?block=1").Code }()
+	<-started
+	// 超过全局并发限制
+	if w := serve(http.MethodPost, "team-c", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("operation beyond the global limit: %d", w.Code)
+	}
+
+	close(finish)
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("blocked operation: %d", code)
+		}
+	}
+	// 操作完成后释放
+	if w := serve(http.MethodPost, "team-a", ""); w.Code != http.StatusOK {
+		t.Errorf("operation after the others finished: %d", w.Code)
+	}
+	if concurrencyLimits.total != 0 || len(concurrencyLimits.byNamespace) != 0 {
+		t.Errorf("limiter is not released: %d, %v", concurrencyLimits.total, concurrencyLimits.byNamespace)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	defaultRefreshInterval   = 30 * time.Minute
+	defaultRefreshJitter     = 0.1
+	defaultRefreshBackoff    = 30 * time.Second
+	defaultRefreshMaxBackoff = 10 * time.Minute
+	refreshScanPeriod        = 5 * time.Second
+)
+
+// 仓库index定时刷新配置
+type repoRefreshConfig struct {
+	Disabled   bool                `yaml:"disabled" json:"disabled"`
+	Interval   duration            `yaml:"interval" json:"interval"`     //默认刷新间隔
+	Jitter     float64             `yaml:"jitter" json:"jitter"`         //刷新间隔的随机浮动比例，0~1
+	Backoff    duration            `yaml:"backoff" json:"backoff"`       //失败后首次重试间隔，之后每次翻倍
+	MaxBackoff duration            `yaml:"maxBackoff" json:"maxBackoff"` //失败重试间隔上限
+	Repos      map[string]duration `yaml:"repos" json:"repos"`           //单个仓库的刷新间隔，0表示不刷新
+}
+
+type repoRefreshStatus struct {
+	Name                string     `json:"name"`
+	Interval            string     `json:"interval"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	ChartCount          int        `json:"chart_count"`
+	NextRefresh         *time.Time `json:"next_refresh,omitempty"`
+}
+
+type repoRefresher struct {
+	mu     sync.Mutex
+	status map[string]*repoRefreshStatus
+	stop   chan struct{}
+}
+
+var refresher = &repoRefresher{status: map[string]*repoRefreshStatus{}}
+
+func refreshConfig() *repoRefreshConfig {
+	rc := currentConfig().RepoRefresh
+	if rc == nil {
+		rc = &repoRefreshConfig{}
+	}
+	return rc
+}
+
+// interval 仓库的刷新间隔，<=0表示不定时刷新
+func (rc *repoRefreshConfig) interval(name string) time.Duration {
+	if rc.Disabled {
+		return 0
+	}
+	if d, ok := rc.Repos[name]; ok {
+		return time.Duration(d)
+	}
+	if rc.Interval > 0 {
+		return time.Duration(rc.Interval)
+	}
+	return defaultRefreshInterval
+}
+
+// next 计算下次刷新时间，成功时按间隔加随机浮动，失败时指数退避
+func (rc *repoRefreshConfig) next(interval time.Duration, failures int) time.Duration {
+	if failures > 0 {
+		backoff, max := time.Duration(rc.Backoff), time.Duration(rc.MaxBackoff)
+		if backoff <= 0 {
+			backoff = defaultRefreshBackoff
+		}
+		if max <= 0 {
+			max = defaultRefreshMaxBackoff
+		}
+		if max > interval {
+			max = interval
+		}
+		for i := 1; i < failures && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			backoff = max
+		}
+		return backoff
+	}
+
+	jitter := rc.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = defaultRefreshJitter
+	}
+	delta := time.Duration((rand.Float64()*2 - 1) * jitter * float64(interval))
+	return interval + delta
+}
+
+func (r *repoRefresher) start() {
+	r.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(refreshScanPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.scan()
+			}
+		}
+	}()
+}
+
+func (r *repoRefresher) shutdown() {
+	if r.stop != nil {
+		close(r.stop)
+	}
+}
+
+// scan 刷新所有到期的仓库，并清理已删除仓库的状态
+func (r *repoRefresher) scan() {
+	rc := refreshConfig()
+	repos := repositories.all()
+	now := time.Now()
+
+	var due []*repoConfig
+	r.mu.Lock()
+	names := map[string]bool{}
+	for _, e := range repos {
+		names[e.Name] = true
+		if isOCIReference(e.URL) || rc.interval(e.Name) <= 0 {
+			continue
+		}
+		s := r.statusLocked(e.Name)
+		if s.NextRefresh == nil || !now.Before(*s.NextRefresh) {
+			// 刷新期间不会再次被选中
+			next := now.Add(rc.interval(e.Name))
+			s.NextRefresh = &next
+			due = append(due, e)
+		}
+	}
+	for name := range r.status {
+		if !names[name] {
+			delete(r.status, name)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, e := range due {
+		go func(e *repoConfig) {
+			result := updateRepository(&e.Entry)
+			if result.Status != repoUpdateOK {
+				glog.Warningf("refresh repo %s failed: %s", e.Name, secrets.mask(result.Error))
+			} else if len(result.NewVersions) > 0 {
+				glog.Infof("refresh repo %s: %d new chart versions", e.Name, len(result.NewVersions))
+			}
+		}(e)
+	}
+}
+
+func (r *repoRefresher) statusLocked(name string) *repoRefreshStatus {
+	s, ok := r.status[name]
+	if !ok {
+		s = &repoRefreshStatus{Name: name}
+		r.status[name] = s
+	}
+	return s
+}
+
+// record 记录一次index下载的结果，定时刷新、启动时初始化和手动更新都会调用；
+// count为新index中的chart数量，由调用方在锁外解析index得到
+func (r *repoRefresher) record(name string, count int, err error) {
+	rc := refreshConfig()
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.statusLocked(name)
+	s.LastAttempt = &now
+	if err != nil {
+		repoRefreshFailuresTotal.WithLabelValues(name).Inc()
+		s.LastError = secrets.mask(err.Error())
+		s.ConsecutiveFailures++
+	} else {
+		s.LastSuccess = &now
+		s.LastError = ""
+		s.ConsecutiveFailures = 0
+		s.ChartCount = count
+	}
+	if interval := rc.interval(name); interval > 0 {
+		next := now.Add(rc.next(interval, s.ConsecutiveFailures))
+		s.NextRefresh = &next
+	} else {
+		s.NextRefresh = nil
+	}
+}
+
+func (r *repoRefresher) list() []repoRefreshStatus {
+	rc := refreshConfig()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := []repoRefreshStatus{}
+	for _, e := range repositories.all() {
+		if isOCIReference(e.URL) {
+			continue
+		}
+		s := *r.statusLocked(e.Name)
+		if interval := rc.interval(e.Name); interval > 0 {
+			s.Interval = interval.String()
+		} else {
+			s.Interval = "disabled"
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// indexChartCount 解析缓存的index得到chart数量，大仓库比较耗时
+func indexChartCount(name string) int {
+	ind, err := repo.LoadIndexFile(filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(name)))
+	if err != nil {
+		return 0
+	}
+	return len(ind.Entries)
+}
+
+// @Summary			查看仓库index刷新状态
+// @Description 	列出每个仓库最近一次刷新成功时间、错误信息和chart数量
+// @Tags			Repository
+// @Success 		200 {object} respBody
+// @Router 			/repos/status [get]
+func listRepositoryStatus(c *gin.Context) {
+	respOK(c, refresher.list())
+}
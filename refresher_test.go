@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		rc   repoRefreshConfig
+		repo string
+		want time.Duration
+	}{
+		{name: "default", want: defaultRefreshInterval},
+		{name: "global", rc: repoRefreshConfig{Interval: duration(time.Hour)}, repo: "stable", want: time.Hour},
+		{name: "per repo", rc: repoRefreshConfig{Interval: duration(time.Hour), Repos: map[string]duration{"stable": duration(10 * time.Minute)}}, repo: "stable", want: 10 * time.Minute},
+		{name: "per repo disabled", rc: repoRefreshConfig{Repos: map[string]duration{"stable": 0}}, repo: "stable", want: 0},
+		{name: "other repo", rc: repoRefreshConfig{Repos: map[string]duration{"stable": 0}}, repo: "incubator", want: defaultRefreshInterval},
+		{name: "disabled", rc: repoRefreshConfig{Disabled: true, Interval: duration(time.Hour)}, repo: "stable", want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.rc.interval(tt.repo); got != tt.want {
+			t.Errorf("%s: interval(%q) = %s, want %s", tt.name, tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		rc       repoRefreshConfig
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{name: "first failure", interval: time.Hour, failures: 1, want: defaultRefreshBackoff},
+		{name: "doubles", interval: time.Hour, failures: 3, want: 4 * defaultRefreshBackoff},
+		{name: "default max", interval: time.Hour, failures: 20, want: defaultRefreshMaxBackoff},
+		{name: "configured", rc: repoRefreshConfig{Backoff: duration(time.Second), MaxBackoff: duration(5 * time.Second)}, interval: time.Hour, failures: 3, want: 4 * time.Second},
+		{name: "configured max", rc: repoRefreshConfig{Backoff: duration(time.Second), MaxBackoff: duration(5 * time.Second)}, interval: time.Hour, failures: 4, want: 5 * time.Second},
+		{name: "not longer than interval", interval: 2 * time.Minute, failures: 10, want: 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := tt.rc.next(tt.interval, tt.failures); got != tt.want {
+			t.Errorf("%s: next(%s, %d) = %s, want %s", tt.name, tt.interval, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshJitter(t *testing.T) {
+	tests := []struct {
+		jitter float64
+		want   float64 // 实际使用的浮动比例
+	}{
+		{0, defaultRefreshJitter},
+		{0.5, 0.5},
+		{2, defaultRefreshJitter},
+	}
+	interval := 10 * time.Minute
+	for _, tt := range tests {
+		rc := repoRefreshConfig{Jitter: tt.jitter}
+		min := interval - time.Duration(tt.want*float64(interval))
+		max := interval + time.Duration(tt.want*float64(interval))
+		varied := false
+		for i := 0; i < 100; i++ {
+			got := rc.next(interval, 0)
+			if got < min || got > max {
+				t.Fatalf("jitter %v: next() = %s, want within [%s, %s]", tt.jitter, got, min, max)
+			}
+			varied = varied || got != interval
+		}
+		if !varied {
+			t.Errorf("jitter %v: next() never varies", tt.jitter)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultLeaseDuration    = 5 * time.Minute
+	defaultRecoverOlderThan = 5 * time.Minute
+
+	leaseNamePrefix          = "helm-proxy."
+	leaseOperationAnnotation = "helm-proxy/operation"
+	leaseHolderAnnotation    = "helm-proxy/holder"
+)
+
+// release锁配置，多副本部署时开启lease，通过k8s Lease在副本间互斥
+type releaseLockConfig struct {
+	Lease         bool     `yaml:"lease" json:"lease"`
+	LeaseDuration duration `yaml:"leaseDuration" json:"leaseDuration"` //lease有效期，操作期间自动续期
+	Identity      string   `yaml:"identity" json:"identity"`           //副本标识，默认为主机名
+	Namespace     string   `yaml:"namespace" json:"namespace"`         //Lease所在的命名空间，默认为helm-proxy所在的命名空间
+}
+
+// releaseLockHolder 当前占用release的操作
+type releaseLockHolder struct {
+	Namespace string    `json:"namespace"`
+	Release   string    `json:"release"`
+	Holder    string    `json:"holder"`
+	Operation string    `json:"operation"`
+	Since     time.Time `json:"since"`
+	Replica   string    `json:"replica,omitempty"`
+}
+
+type releaseLocker struct {
+	mu   sync.Mutex
+	held map[string]*releaseLockHolder
+}
+
+var releaseLocks = &releaseLocker{held: map[string]*releaseLockHolder{}}
+
+func lockConfig() *releaseLockConfig {
+	lc := currentConfig().ReleaseLock
+	if lc == nil {
+		lc = &releaseLockConfig{}
+	}
+	return lc
+}
+
+func (lc *releaseLockConfig) identity() string {
+	if lc.Identity != "" {
+		return lc.Identity
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// namespace Lease统一放在helm-proxy自己的命名空间，release的命名空间可能还不存在(create_namespace)
+func (lc *releaseLockConfig) namespace() string {
+	if lc.Namespace != "" {
+		return lc.Namespace
+	}
+	return settings.Namespace()
+}
+
+func (lc *releaseLockConfig) leaseDuration() time.Duration {
+	if lc.LeaseDuration > 0 {
+		return time.Duration(lc.LeaseDuration)
+	}
+	return defaultLeaseDuration
+}
+
+// acquire 获取release锁，release正被占用时返回占用者
+func (l *releaseLocker) acquire(h *releaseLockHolder) (unlock func(), busy *releaseLockHolder, err error) {
+	key := h.Namespace + "/" + h.Release
+
+	l.mu.Lock()
+	if held, ok := l.held[key]; ok {
+		l.mu.Unlock()
+		return nil, held, nil
+	}
+	l.held[key] = h
+	l.mu.Unlock()
+
+	unlockLocal := func() {
+		l.mu.Lock()
+		delete(l.held, key)
+		l.mu.Unlock()
+	}
+
+	lc := lockConfig()
+	if !lc.Lease {
+		return unlockLocal, nil, nil
+	}
+	clientset, err := kubeClientset(lc.namespace())
+	if err != nil {
+		unlockLocal()
+		return nil, nil, err
+	}
+	h.Replica = lc.identity()
+	releaseLease, busy, err := acquireLease(clientset, lc.namespace(), h, lc.leaseDuration())
+	if err != nil || busy != nil {
+		unlockLocal()
+		return nil, busy, err
+	}
+	return func() {
+		releaseLease()
+		unlockLocal()
+	}, nil, nil
+}
+
+// leaseName release对应的Lease名称，命名空间中不能包含"."，不会与其他release重名
+func leaseName(h *releaseLockHolder) string {
+	return leaseNamePrefix + h.Namespace + "." + h.Release
+}
+
+// acquireLease 在namespace中创建或接管已过期的Lease，持有期间定时续期
+func acquireLease(clientset kubernetes.Interface, namespace string, h *releaseLockHolder, leaseDuration time.Duration) (func(), *releaseLockHolder, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	name := leaseName(h)
+	seconds := int32(leaseDuration / time.Second)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+	exists := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	case err != nil:
+		return nil, nil, err
+	default:
+		if busy := leaseHolder(lease, h); busy != nil {
+			return nil, busy, nil
+		}
+	}
+
+	lease.Annotations = map[string]string{leaseOperationAnnotation: h.Operation, leaseHolderAnnotation: h.Holder}
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity:       &h.Replica,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+	if !exists {
+		lease, err = leases.Create(context.Background(), lease, metav1.CreateOptions{})
+	} else {
+		lease, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	}
+	// 其他副本同时获取成功
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		if current, err := leases.Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+			if busy := leaseHolder(current, h); busy != nil {
+				return nil, busy, nil
+			}
+		}
+		return nil, &releaseLockHolder{Namespace: h.Namespace, Release: h.Release, Operation: "unknown", Since: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(leaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renew := metav1.NewMicroTime(time.Now())
+				lease.Spec.RenewTime = &renew
+				updated, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+				if err != nil {
+					glog.Warningf("failed to renew lease %s/%s: %v", namespace, name, err)
+					continue
+				}
+				lease = updated
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		err := leases.Delete(context.Background(), name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			glog.Warningf("failed to release lease %s/%s: %v", namespace, name, err)
+		}
+	}, nil, nil
+}
+
+// leaseHolder Lease未过期且由其他副本持有时返回持有者。
+// 调用时本进程已持有该release的本地锁，持有者是自己说明是重启前或释放失败留下的Lease，可以直接接管
+func leaseHolder(lease *coordinationv1.Lease, h *releaseLockHolder) *releaseLockHolder {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return nil
+	}
+	if *spec.HolderIdentity == h.Replica {
+		return nil
+	}
+	expire := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	if time.Now().After(expire) {
+		return nil
+	}
+	busy := &releaseLockHolder{
+		Namespace: h.Namespace,
+		Release:   h.Release,
+		Holder:    lease.Annotations[leaseHolderAnnotation],
+		Operation: lease.Annotations[leaseOperationAnnotation],
+		Replica:   *spec.HolderIdentity,
+	}
+	if spec.AcquireTime != nil {
+		busy.Since = spec.AcquireTime.Time
+	}
+	return busy
+}
+
+// list 当前被占用的release
+func (l *releaseLocker) list() []*releaseLockHolder {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]*releaseLockHolder, 0, len(l.held))
+	for _, h := range l.held {
+		list = append(list, h)
+	}
+	return list
+}
+
+// lockRelease 对release的变更操作加锁，release正被其他请求操作时返回409
+func lockRelease(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := &releaseLockHolder{
+			Namespace: c.Param("namespace"),
+			Release:   c.Param("release"),
+			Holder:    requestUser(c),
+			Operation: operation,
+			Since:     time.Now(),
+		}
+		unlock, busy, err := releaseLocks.acquire(h)
+		if err != nil {
+			respErr(c, errors.Wrap(err, "failed to lock release"))
+			c.Abort()
+			return
+		}
+		if busy != nil {
+			respErrStatus(c, http.StatusConflict, errors.Errorf("release %s/%s is busy: %s by %s since %s",
+				busy.Namespace, busy.Release, busy.Operation, busy.Holder, busy.Since.Format(time.RFC3339)), busy)
+			c.Abort()
+			return
+		}
+		defer unlock()
+
+		releaseOperationsInFlight.WithLabelValues(operation).Inc()
+		defer releaseOperationsInFlight.WithLabelValues(operation).Dec()
+		c.Next()
+	}
+}
+
+// @Summary			恢复卡住的release
+// @Description 	release长时间处于pending-install/pending-upgrade/pending-rollback状态时，将其标记为failed，rollback为true时再回滚到最近一次成功的版本
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			release path string true "release名称"
+// @Param 			older_than query string false "只恢复超过该时间未变化的release，默认5m"
+// @Param 			rollback query bool false "标记为failed后是否回滚到最近一次成功的版本"
+// @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
+// @Router 			/namespaces/{namespace}/releases/{release}/recover [post]
+func recoverRelease(c *gin.Context) {
+	name := c.Param("release")
+	namespace := c.Param("namespace")
+	olderThan := defaultRecoverOlderThan
+	if v := c.Query("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			respErr(c, badRequestf("bad older_than %q", v))
+			return
+		}
+		olderThan = d
+	}
+	rollback := c.Query("rollback") == "true"
+
+	actionConfig, err := actionConfigInit(c, namespace)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	rel, err := recoverPendingRelease(actionConfig, name, olderThan, rollback)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	removeInterrupted(namespace, name)
+	respOK(c, constructReleaseElement(rel, false))
+}
+
+func recoverPendingRelease(actionConfig *action.Configuration, name string, olderThan time.Duration, rollback bool) (*release.Release, error) {
+	last, err := actionConfig.Releases.Last(name)
+	if err != nil {
+		return nil, err
+	}
+	status := last.Info.Status
+	if status != release.StatusPendingInstall && status != release.StatusPendingUpgrade && status != release.StatusPendingRollback {
+		return nil, errors.Errorf("release %s is %s, not in a pending state", name, status)
+	}
+	if age := time.Since(last.Info.LastDeployed.Time); age < olderThan {
+		return nil, errors.Errorf("release %s has been %s for %s only, it may still be in progress", name, status, age.Round(time.Second))
+	}
+
+	last.SetStatus(release.StatusFailed, fmt.Sprintf("marked as failed by helm-proxy: stuck in %s", status))
+	if err := actionConfig.Releases.Update(last); err != nil {
+		return nil, err
+	}
+	if !rollback {
+		return last, nil
+	}
+
+	deployed, err := lastDeployedRevision(actionConfig, name)
+	if err != nil {
+		return last, err
+	}
+	client := action.NewRollback(actionConfig)
+	client.Version = deployed
+	if err := client.Run(name); err != nil {
+		return last, errors.Wrapf(err, "release %s is marked as failed, but failed to roll back to revision %d", name, deployed)
+	}
+	return actionConfig.Releases.Last(name)
+}
+
+func lastDeployedRevision(actionConfig *action.Configuration, name string) (int, error) {
+	history, err := actionConfig.Releases.History(name)
+	if err != nil {
+		return 0, err
+	}
+	revision := 0
+	for _, r := range history {
+		if (r.Info.Status == release.StatusDeployed || r.Info.Status == release.StatusSuperseded) && r.Version > revision {
+			revision = r.Version
+		}
+	}
+	if revision == 0 {
+		return 0, errors.Errorf("release %s has no successfully deployed revision to roll back to", name)
+	}
+	return revision, nil
+}
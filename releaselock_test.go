@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAcquireLeaseInProxyNamespace(t *testing.T) {
+	// release的命名空间还不存在，Lease只在helm-proxy的命名空间中创建
+	clientset := fake.NewSimpleClientset()
+	h := &releaseLockHolder{Namespace: "new-ns", Release: "web", Holder: "alice", Operation: "install", Replica: "replica-0"}
+	release, busy, err := acquireLease(clientset, "helm-proxy", h, time.Minute)
+	if err != nil || busy != nil {
+		t.Fatalf("acquireLease() = %v, %v", busy, err)
+	}
+
+	leases, err := clientset.CoordinationV1().Leases("helm-proxy").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Items) != 1 || leases.Items[0].Name != "helm-proxy.new-ns.web" {
+		t.Fatalf("unexpected leases %+v", leases.Items)
+	}
+	if others, _ := clientset.CoordinationV1().Leases("new-ns").List(context.Background(), metav1.ListOptions{}); len(others.Items) != 0 {
+		t.Errorf("lease is created in the release namespace: %+v", others.Items)
+	}
+
+	// 同名release在其他命名空间中不冲突，同一release被其他副本占用
+	other := &releaseLockHolder{Namespace: "other-ns", Release: "web", Holder: "bob", Operation: "upgrade", Replica: "replica-1"}
+	releaseOther, busy, err := acquireLease(clientset, "helm-proxy", other, time.Minute)
+	if err != nil || busy != nil {
+		t.Fatalf("release in another namespace is locked: %v, %v", busy, err)
+	}
+	releaseOther()
+	same := &releaseLockHolder{Namespace: "new-ns", Release: "web", Holder: "bob", Operation: "upgrade", Replica: "replica-1"}
+	if _, busy, err := acquireLease(clientset, "helm-proxy", same, time.Minute); err != nil || busy == nil || busy.Holder != "alice" {
+		t.Errorf("acquireLease() of a held release = %+v, %v", busy, err)
+	}
+
+	release()
+	if leases, _ := clientset.CoordinationV1().Leases("helm-proxy").List(context.Background(), metav1.ListOptions{}); len(leases.Items) != 0 {
+		t.Errorf("lease is not deleted after release: %+v", leases.Items)
+	}
+}
+
+func TestAcquireLeaseTakesOverOwnLease(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	h := &releaseLockHolder{Namespace: "default", Release: "web", Holder: "alice", Operation: "install", Replica: "replica-0"}
+	if _, busy, err := acquireLease(clientset, "helm-proxy", h, time.Minute); err != nil || busy != nil {
+		t.Fatalf("acquireLease() = %v, %v", busy, err)
+	}
+
+	// 未释放的Lease(如进程重启)由同一副本再次获取时接管，不认为release被占用
+	again := &releaseLockHolder{Namespace: "default", Release: "web", Holder: "bob", Operation: "upgrade", Replica: "replica-0"}
+	release, busy, err := acquireLease(clientset, "helm-proxy", again, time.Minute)
+	if err != nil || busy != nil {
+		t.Fatalf("own lease is not taken over: %+v, %v", busy, err)
+	}
+	lease, err := clientset.CoordinationV1().Leases("helm-proxy").Get(context.Background(), "helm-proxy.default.web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Annotations[leaseHolderAnnotation] != "bob" || lease.Annotations[leaseOperationAnnotation] != "upgrade" {
+		t.Errorf("lease annotations %v, want the new holder", lease.Annotations)
+	}
+
+	// 其他副本仍然被拒绝
+	other := &releaseLockHolder{Namespace: "default", Release: "web", Holder: "carol", Operation: "rollback", Replica: "replica-1"}
+	if _, busy, err := acquireLease(clientset, "helm-proxy", other, time.Minute); err != nil || busy == nil || busy.Holder != "bob" {
+		t.Errorf("acquireLease() by another replica = %+v, %v", busy, err)
+	}
+	release()
+}
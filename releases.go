@@ -1,15 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang/glog"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
@@ -77,24 +81,24 @@ type releaseOptions struct {
 // helm List struct
 type releaseListOptions struct {
 	// All ignores the limit/offset
-	All bool `json:"all"`
+	All bool `json:"all" form:"all"`
 	// AllNamespaces searches across namespaces
-	AllNamespaces bool `json:"all_namespaces"`
+	AllNamespaces bool `json:"all_namespaces" form:"all_namespaces"`
 	// Overrides the default lexicographic sorting
-	ByDate      bool `json:"by_date"`
-	SortReverse bool `json:"sort_reverse"`
+	ByDate      bool `json:"by_date" form:"by_date"`
+	SortReverse bool `json:"sort_reverse" form:"sort_reverse"`
 	// Limit is the number of items to return per Run()
-	Limit int `json:"limit"`
+	Limit int `json:"limit" form:"limit"`
 	// Offset is the starting index for the Run() call
-	Offset int `json:"offset"`
+	Offset int `json:"offset" form:"offset"`
 	// Filter is a filter that is applied to the results
-	Filter       string `json:"filter"`
-	Uninstalled  bool   `json:"uninstalled"`
-	Superseded   bool   `json:"superseded"`
-	Uninstalling bool   `json:"uninstalling"`
-	Deployed     bool   `json:"deployed"`
-	Failed       bool   `json:"failed"`
-	Pending      bool   `json:"pending"`
+	Filter       string `json:"filter" form:"filter"`
+	Uninstalled  bool   `json:"uninstalled" form:"uninstalled"`
+	Superseded   bool   `json:"superseded" form:"superseded"`
+	Uninstalling bool   `json:"uninstalling" form:"uninstalling"`
+	Deployed     bool   `json:"deployed" form:"deployed"`
+	Failed       bool   `json:"failed" form:"failed"`
+	Pending      bool   `json:"pending" form:"pending"`
 }
 
 func formatChartname(c *chart.Chart) string {
@@ -203,20 +207,22 @@ func isChartInstallable(ch *chart.Chart) (bool, error) {
 // @Success 		200 {object} respBody
 // @Router 			/namespaces/{namespace}/releases/{release} [get]
 func showReleaseInfo(c *gin.Context) {
-	name := c.Param("release")
-	namespace := c.Param("namespace")
-	info := c.Query("info")
+	respReleaseInfo(c, c.Param("namespace"), c.Param("release"), c.Query("info"))
+}
+
+// respReleaseInfo 按info返回release的全部或部分信息，v1和v2接口共用
+func respReleaseInfo(c *gin.Context, namespace, name, info string) {
 	infos := []string{"all", "hooks", "manifest", "notes", "values"}
 	infoMap := map[string]bool{}
 	for _, i := range infos {
 		infoMap[i] = true
 	}
 	if _, ok := infoMap[info]; !ok {
-		respErr(c, fmt.Errorf("bad info %s, release info only support all/hooks/manifest/notes/values", info))
+		respErr(c, badRequestf("bad info %s, release info only support all/hooks/manifest/notes/values", info))
 		return
 	}
 
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -259,42 +265,48 @@ func showReleaseInfo(c *gin.Context) {
 }
 
 // @Summary			安装release
-// @Description 	安装chart的实例(helm install)
+// @Description 	安装chart的实例(helm install)。不传chart时可直接提供chart：multipart/form-data上传chart压缩包(chart字段，安装可选项以json放在options字段)，或在json的chart字段中提供ChartView格式的chart；需要校验签名时只能上传压缩包，并在prov字段上传.prov文件
 // @Tags			Release
 // @Param 			namespace path string true "release所在k8s的命名空间"
 // @Param 			release path string true "release名称"
-// @Param 			chart query string true "chart名称"
-// @Param 			options body releaseOptions true "安装可选项""
+// @Param 			chart query string false "chart名称"
+// @Param 			options body inlineInstallOptions true "安装可选项""
 // @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
 // @Router 			/namespaces/{namespace}/releases/{release} [post]
 func installRelease(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
 	chart := c.Query("chart")
-	if chart == "" {
-		respErr(c, fmt.Errorf("chart name can not be empty"))
+
+	var options inlineInstallOptions
+	inline, err := bindInstallOptions(c, &options)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	if chart == "" && inline == nil {
+		respErr(c, badRequestf("chart name can not be empty"))
+		return
+	}
+	if chart != "" && inline != nil {
+		respErr(c, badRequestf("chart name and inline chart can not be specified at the same time"))
 		return
 	}
 
 	// install with local uploaded charts, *.tgz
 	splitChart := strings.Split(chart, ".")
 	if splitChart[len(splitChart)-1] == "tgz" {
-		chart = helmConfig.UploadPath + "/" + chart
+		chart = currentConfig().UploadPath + "/" + chart
 	}
 
-	var options releaseOptions
-	err := c.ShouldBindJSON(&options)
-	if err != nil && err != io.EOF {
-		respErr(c, err)
-		return
-	}
-	vals, err := mergeValues(options)
+	vals, err := mergeValues(options.releaseOptions)
 	if err != nil {
 		respErr(c, err)
 		return
 	}
 
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -315,15 +327,114 @@ func installRelease(c *gin.Context) {
 	client.Timeout = options.Timeout
 	client.CreateNamespace = options.CreateNamespace
 	client.DependencyUpdate = options.DependencyUpdate
-	if rel, err := runInstall(chart, client, vals); err != nil {
+
+	var rel *release.Release
+	if inline != nil {
+		setAuditHelmChart(c, inline)
+		rel, err = runInstallChart(inline, "", client, vals)
+	} else {
+		rel, err = runInstall(chart, client, vals)
+	}
+	if rel != nil {
+		setAuditHelmChart(c, rel.Chart)
+	}
+	if err != nil {
 		respErr(c, err)
 	} else {
 		respOK(c, rel)
 	}
 }
 
+// inlineInstallOptions 安装可选项，chart字段为直接提供的chart
+type inlineInstallOptions struct {
+	releaseOptions
+	Chart *ChartView `json:"chart"`
+}
+
+// bindInstallOptions 解析安装可选项，返回请求中直接提供的chart，没有则返回nil
+func bindInstallOptions(c *gin.Context, options *inlineInstallOptions) (*chart.Chart, error) {
+	if c.ContentType() == binding.MIMEMultipartPOSTForm {
+		if o := c.PostForm("options"); o != "" {
+			if err := json.Unmarshal([]byte(o), &options.releaseOptions); err != nil {
+				return nil, errors.Wrap(err, "failed parsing options")
+			}
+		}
+		file, header, err := c.Request.FormFile("chart")
+		if err == http.ErrMissingFile {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		uploadSizeBytes.WithLabelValues("inline").Observe(float64(header.Size))
+
+		opts := &action.ChartPathOptions{}
+		if err := applyProvenance(opts, ""); err != nil {
+			return nil, err
+		}
+		if !opts.Verify {
+			return loader.LoadArchive(file)
+		}
+		return loadVerifiedArchive(c, file, header.Filename, opts.Keyring)
+	}
+
+	err := c.ShouldBindJSON(options)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if options.Chart == nil {
+		return nil, nil
+	}
+	// ChartView格式的chart没有签名，需要校验签名时只能上传chart压缩包和.prov文件
+	opts := &action.ChartPathOptions{}
+	if err := applyProvenance(opts, ""); err != nil {
+		return nil, err
+	}
+	if opts.Verify {
+		return nil, badRequestf("provenance verification is required, upload the chart archive with its .prov file instead")
+	}
+	return options.Chart.helmChart()
+}
+
+// loadVerifiedArchive 校验与chart压缩包一起上传的.prov文件(prov字段)后加载chart
+func loadVerifiedArchive(c *gin.Context, file io.Reader, filename, keyring string) (*chart.Chart, error) {
+	prov, _, err := c.Request.FormFile("prov")
+	if err == http.ErrMissingFile {
+		return nil, badRequestf("provenance verification is required, but no .prov file is uploaded")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer prov.Close()
+
+	dir, err := ioutil.TempDir(currentConfig().SnapPath, "inline-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// .prov中按文件名记录摘要，保留上传时的文件名
+	cp := filepath.Join(dir, filepath.Base(filename))
+	for dst, src := range map[string]io.Reader{cp: file, cp + ".prov": prov} {
+		out, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, src)
+		out.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := downloader.VerifyChart(cp, keyring); err != nil {
+		return nil, badRequestf("provenance verification of %s failed: %v", filename, err)
+	}
+	return loader.Load(cp)
+}
+
 func runInstall(chart string, client *action.Install, vals map[string]interface{}) (*release.Release, error) {
-	cp, err := client.ChartPathOptions.LocateChart(chart, settings)
+	cp, err := locateChart(&client.ChartPathOptions, chart)
 	if err != nil {
 		return nil, err
 	}
@@ -333,6 +444,11 @@ func runInstall(chart string, client *action.Install, vals map[string]interface{
 		return nil, err
 	}
 
+	return runInstallChart(chartRequested, cp, client, vals)
+}
+
+// runInstallChart cp为chart的本地路径，直接提供的chart没有路径，无法更新依赖
+func runInstallChart(chartRequested *chart.Chart, cp string, client *action.Install, vals map[string]interface{}) (*release.Release, error) {
 	validInstallableChart, err := isChartInstallable(chartRequested)
 	if !validInstallableChart {
 		return nil, err
@@ -343,7 +459,7 @@ func runInstall(chart string, client *action.Install, vals map[string]interface{
 		// As of Helm 2.4.0, this is treated as a stopping condition:
 		// https://github.com/helm/helm/issues/2209
 		if err := action.CheckDependencies(chartRequested, req); err != nil {
-			if client.DependencyUpdate {
+			if client.DependencyUpdate && cp != "" {
 				man := &downloader.Manager{
 					ChartPath:        cp,
 					Keyring:          client.ChartPathOptions.Keyring,
@@ -370,11 +486,12 @@ func runInstall(chart string, client *action.Install, vals map[string]interface{
 // @Param 			namespace path string true "release所在k8s的命名空间"
 // @Param 			release path string true "release名称"
 // @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
 // @Router 			/namespaces/{namespace}/releases/{release} [delete]
 func uninstallRelease(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -396,18 +513,22 @@ func uninstallRelease(c *gin.Context) {
 // @Param 			release path string true "release名称"
 // @Param 			versions path string true "chart版本号"
 // @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
 // @Router 			/namespaces/{namespace}/releases/{release}/versions/{reversion} [put]
 func rollbackRelease(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
 	reversionStr := c.Param("reversion")
+	if reversionStr == "" {
+		reversionStr = c.Param("revision")
+	}
 	reversion, err := strconv.Atoi(reversionStr)
 	if err != nil {
-		respErr(c, err)
+		respErr(c, badRequestf("%v", err))
 		return
 	}
 
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -430,20 +551,21 @@ func rollbackRelease(c *gin.Context) {
 // @Param 			release path string true "release名称"
 // @Param 			chart query string false "chart名称"
 // @Success 		200 {object} respBody
+// @Failure 		409 {object} respBody "release正被其他请求操作"
 // @Router 			/namespaces/{namespace}/releases/{release} [put]
 func upgradeRelease(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
 	chart := c.Query("chart")
 	if chart == "" {
-		respErr(c, fmt.Errorf("chart name can not be empty"))
+		respErr(c, badRequestf("chart name can not be empty"))
 		return
 	}
 
 	// upgrade with local uploaded charts *.tgz
 	splitChart := strings.Split(chart, ".")
 	if splitChart[len(splitChart)-1] == "tgz" {
-		chart = helmConfig.UploadPath + "/" + chart
+		chart = currentConfig().UploadPath + "/" + chart
 	}
 
 	var options releaseOptions
@@ -459,7 +581,7 @@ func upgradeRelease(c *gin.Context) {
 		return
 	}
 
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -482,7 +604,7 @@ func upgradeRelease(c *gin.Context) {
 	client.Recreate = options.Recreate
 	client.CleanupOnFail = options.CleanupOnFail
 
-	cp, err := client.ChartPathOptions.LocateChart(chart, settings)
+	cp, err := locateChart(&client.ChartPathOptions, chart)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -493,6 +615,7 @@ func upgradeRelease(c *gin.Context) {
 		respErr(c, err)
 		return
 	}
+	setAuditHelmChart(c, chartRequested)
 	if req := chartRequested.Metadata.Dependencies; req != nil {
 		if err := action.CheckDependencies(chartRequested, req); err != nil {
 			respErr(c, err)
@@ -516,16 +639,19 @@ func upgradeRelease(c *gin.Context) {
 // @Success 		200 {object} respBody
 // @Router 			/namespaces/{namespace}/releases [get]
 func listReleases(c *gin.Context) {
-	namespace := c.Param("namespace")
-	actionConfig, err := actionConfigInit(namespace)
-	if err != nil {
+	var options releaseListOptions
+	err := c.ShouldBindJSON(&options)
+	if err != nil && err != io.EOF {
 		respErr(c, err)
 		return
 	}
+	respReleaseList(c, c.Param("namespace"), &options)
+}
 
-	var options releaseListOptions
-	err = c.ShouldBindJSON(&options)
-	if err != nil && err != io.EOF {
+// respReleaseList v1从请求体读取过滤条件，v2从查询参数读取
+func respReleaseList(c *gin.Context, namespace string, options *releaseListOptions) {
+	actionConfig, err := actionConfigInit(c, namespace)
+	if err != nil {
 		respErr(c, err)
 		return
 	}
@@ -536,7 +662,7 @@ func listReleases(c *gin.Context) {
 	client.All = options.All
 	client.AllNamespaces = options.AllNamespaces
 	if client.AllNamespaces {
-		err = actionConfig.Init(settings.RESTClientGetter(), "", os.Getenv("HELM_DRIVER"), glog.Infof)
+		err = actionConfig.Init(settings.RESTClientGetter(), "", os.Getenv("HELM_DRIVER"), requestLogf(c))
 		if err != nil {
 			respErr(c, err)
 			return
@@ -580,7 +706,7 @@ func listReleases(c *gin.Context) {
 func getReleaseStatus(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -607,7 +733,7 @@ func getReleaseStatus(c *gin.Context) {
 func listReleaseHistories(c *gin.Context) {
 	name := c.Param("release")
 	namespace := c.Param("namespace")
-	actionConfig, err := actionConfigInit(namespace)
+	actionConfig, err := actionConfigInit(c, namespace)
 	if err != nil {
 		respErr(c, err)
 		return
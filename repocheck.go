@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	repoCheckTimeout = 10 * time.Second
+	// 证书有效期不足该时长时标记为即将过期
+	certExpiryWarning = 30 * 24 * time.Hour
+
+	checkOK      = "ok"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+type repoCheckStage struct {
+	Name     string `json:"name"` // dns, tls, auth, index
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type repoCheckCertificate struct {
+	Source        string    `json:"source"` // caFile, certFile, server
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Expired       bool      `json:"expired"`
+	ExpiresSoon   bool      `json:"expires_soon"`
+}
+
+type repoCheckIndex struct {
+	SizeBytes    int        `json:"size_bytes"`
+	ChartCount   int        `json:"chart_count"`
+	VersionCount int        `json:"version_count"`
+	Generated    time.Time  `json:"generated"`
+	GeneratedAge string     `json:"generated_age"`
+	CachedAt     *time.Time `json:"cached_at,omitempty"` // 本地缓存index的时间
+	CacheAge     string     `json:"cache_age,omitempty"`
+}
+
+type repoCheckReport struct {
+	Name         string                 `json:"name"`
+	URL          string                 `json:"url"`
+	OK           bool                   `json:"ok"`
+	Stages       []repoCheckStage       `json:"stages"`
+	Certificates []repoCheckCertificate `json:"certificates"`
+	Index        *repoCheckIndex        `json:"index,omitempty"`
+}
+
+func (r *repoCheckReport) stage(name string, start time.Time, err error) bool {
+	s := repoCheckStage{Name: name, Status: checkOK, Duration: time.Since(start).String()}
+	if err != nil {
+		s.Status = checkFailed
+		s.Message = secrets.mask(err.Error())
+		r.OK = false
+	}
+	r.Stages = append(r.Stages, s)
+	return err == nil
+}
+
+func (r *repoCheckReport) skip(message string, names ...string) {
+	for _, name := range names {
+		r.Stages = append(r.Stages, repoCheckStage{Name: name, Status: checkSkipped, Message: message})
+	}
+}
+
+// checkRepository 依次检查DNS解析、TLS握手、认证和index解析，某一步失败后后续步骤跳过
+func checkRepository(e *repo.Entry) *repoCheckReport {
+	report := &repoCheckReport{Name: e.Name, URL: secrets.mask(e.URL), OK: true, Stages: []repoCheckStage{}, Certificates: []repoCheckCertificate{}}
+	report.Certificates = append(report.Certificates, fileCertificates("caFile", e.CAFile)...)
+	report.Certificates = append(report.Certificates, fileCertificates("certFile", e.CertFile)...)
+
+	rawURL := e.URL
+	oci := isOCIReference(rawURL)
+	if oci {
+		rawURL = "https://" + strings.TrimPrefix(rawURL, ociScheme)
+		if findRegistryConfig(hostOf(rawURL)).PlainHTTP {
+			rawURL = "http://" + strings.TrimPrefix(rawURL, "https://")
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Host == "" {
+		err = errors.Errorf("invalid repository url %q", e.URL)
+	}
+	if err != nil {
+		report.stage("dns", time.Now(), err)
+		report.skip("invalid url", "tls", "auth", "index")
+		return report
+	}
+
+	// DNS
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), repoCheckTimeout)
+	defer cancel()
+	if net.ParseIP(u.Hostname()) == nil {
+		_, err = net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	}
+	if !report.stage("dns", start, err) {
+		report.skip("dns failed", "tls", "auth", "index")
+		return report
+	}
+
+	// TLS
+	tlsConfig, err := newTLSConfig(e.CertFile, e.KeyFile, e.CAFile, e.InsecureSkipTLSverify)
+	if err != nil {
+		report.stage("tls", time.Now(), err)
+		report.skip("tls failed", "auth", "index")
+		return report
+	}
+	if u.Scheme == "https" {
+		start = time.Now()
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		conf := tlsConfig.Clone()
+		conf.ServerName = u.Hostname()
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: repoCheckTimeout}, "tcp", host, conf)
+		if err == nil {
+			for _, cert := range conn.ConnectionState().PeerCertificates {
+				report.Certificates = append(report.Certificates, newRepoCheckCertificate("server", cert))
+			}
+			conn.Close()
+		}
+		if !report.stage("tls", start, err) {
+			report.skip("tls failed", "auth", "index")
+			return report
+		}
+	} else {
+		report.skip("plain http", "tls")
+	}
+
+	if oci {
+		report.skip("oci registry has no index", "auth", "index")
+		return report
+	}
+
+	// auth & index
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: repoCheckTimeout}
+	start = time.Now()
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(e.URL, "/")+"/index.yaml", nil)
+	if err != nil {
+		report.stage("auth", start, err)
+		report.skip("auth failed", "index")
+		return report
+	}
+	if e.Username != "" && e.Password != "" {
+		req.SetBasicAuth(e.Username, e.Password)
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			err = errors.Errorf("%s, check username and password", resp.Status)
+		}
+	}
+	if !report.stage("auth", start, err) {
+		report.skip("auth failed", "index")
+		return report
+	}
+
+	start = time.Now()
+	report.Index, err = checkIndex(resp)
+	report.stage("index", start, err)
+	if report.Index != nil {
+		cached := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(e.Name))
+		if st, err := os.Stat(cached); err == nil {
+			t := st.ModTime()
+			report.Index.CachedAt = &t
+			report.Index.CacheAge = time.Since(t).Round(time.Second).String()
+		}
+	}
+	return report
+}
+
+func checkIndex(resp *http.Response) (*repoCheckIndex, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch index.yaml: %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ind := &repo.IndexFile{}
+	if err := yaml.Unmarshal(b, ind); err != nil {
+		return nil, errors.Wrap(err, "index.yaml is not valid yaml")
+	}
+	if ind.APIVersion == "" {
+		return nil, repo.ErrNoAPIVersion
+	}
+
+	info := &repoCheckIndex{
+		SizeBytes:    len(b),
+		ChartCount:   len(ind.Entries),
+		Generated:    ind.Generated,
+		GeneratedAge: time.Since(ind.Generated).Round(time.Second).String(),
+	}
+	for _, versions := range ind.Entries {
+		info.VersionCount += len(versions)
+	}
+	return info, nil
+}
+
+// fileCertificates 解析PEM文件中的证书，文件不存在或无法解析时返回一个说明项
+func fileCertificates(source, file string) []repoCheckCertificate {
+	if file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return []repoCheckCertificate{{Source: source, Subject: fmt.Sprintf("unreadable: %v", err)}}
+	}
+	var certs []repoCheckCertificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, newRepoCheckCertificate(source, cert))
+	}
+	return certs
+}
+
+func newRepoCheckCertificate(source string, cert *x509.Certificate) repoCheckCertificate {
+	remaining := time.Until(cert.NotAfter)
+	return repoCheckCertificate{
+		Source:        source,
+		Subject:       cert.Subject.String(),
+		Issuer:        cert.Issuer.String(),
+		NotAfter:      cert.NotAfter,
+		DaysRemaining: int(remaining.Hours() / 24),
+		Expired:       remaining < 0,
+		ExpiresSoon:   remaining >= 0 && remaining < certExpiryWarning,
+	}
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// @Summary			检查chart镜像库
+// @Description 	检查已添加仓库的DNS解析、TLS握手、认证、index解析，以及证书有效期和index新旧
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Success 		200 {object} respBody
+// @Router 			/repos/{repo}/check [post]
+func checkRepositoryByName(c *gin.Context) {
+	e, ok := repositories.get(c.Param("repo"))
+	if !ok {
+		respErr(c, notFoundf("no repo named %q found", c.Param("repo")))
+		return
+	}
+	respCheckReport(c, checkRepository(&e.Entry))
+}
+
+// @Summary			添加前检查chart镜像库
+// @Description 	添加仓库前检查DNS解析、TLS握手、认证、index解析，以及证书有效期
+// @Tags			Repository
+// @Param           repoinfo body repoAddOptions true "仓库信息"
+// @Success 		200 {object} respBody
+// @Router 			/repos/check [post]
+func checkNewRepository(c *gin.Context) {
+	var o repoAddOptions
+	if c.Bind(&o) != nil {
+		respErr(c, badRequestf("missing parameters"))
+		return
+	}
+	if err := checkCredentialSources(o.UsernameFrom, o.PasswordFrom); err != nil {
+		respErr(c, err)
+		return
+	}
+	e := o.entry()
+	if err := e.resolveCredentials(); err != nil {
+		respErr(c, err)
+		return
+	}
+	respCheckReport(c, checkRepository(&e.Entry))
+}
+
+func respCheckReport(c *gin.Context, report *repoCheckReport) {
+	if !report.OK {
+		respErrData(c, errors.Errorf("repository %s check failed", report.URL), report)
+		return
+	}
+	respOK(c, report)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 1.0.0
+  - name: nginx
+    version: 1.1.0
+generated: "2020-08-13T10:00:00Z"
+`
+
+// writeTestCert 生成自签名证书写入dir，返回证书文件路径
+func writeTestCert(t *testing.T, dir, name string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func stageStatus(report *repoCheckReport) map[string]string {
+	status := map[string]string{}
+	for _, s := range report.Stages {
+		status[s.Name] = s.Status
+	}
+	return status
+}
+
+func checkStages(t *testing.T, report *repoCheckReport, want map[string]string) {
+	t.Helper()
+	got := stageStatus(report)
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("stage %s is %q, want %q (stages %+v)", name, got[name], status, report.Stages)
+		}
+	}
+}
+
+func TestCheckRepositoryDNSFailure(t *testing.T) {
+	report := checkRepository(&repo.Entry{Name: "missing", URL: "http://charts.helm-proxy-test.invalid/"})
+	if report.OK {
+		t.Error("report is ok for an unresolvable host")
+	}
+	checkStages(t, report, map[string]string{"dns": checkFailed, "tls": checkSkipped, "auth": checkSkipped, "index": checkSkipped})
+}
+
+func TestCheckRepositoryTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIndex))
+	}))
+	defer ts.Close()
+
+	// 服务端证书不受信任
+	report := checkRepository(&repo.Entry{Name: "tls", URL: ts.URL})
+	if report.OK {
+		t.Error("report is ok for an untrusted server certificate")
+	}
+	checkStages(t, report, map[string]string{"dns": checkOK, "tls": checkFailed, "auth": checkSkipped, "index": checkSkipped})
+
+	dir, err := ioutil.TempDir("", "helm-proxy-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = checkRepository(&repo.Entry{Name: "tls", URL: ts.URL, CAFile: caFile})
+	if !report.OK {
+		t.Errorf("report is not ok with the server certificate as caFile: %+v", report.Stages)
+	}
+	checkStages(t, report, map[string]string{"dns": checkOK, "tls": checkOK, "auth": checkOK, "index": checkOK})
+	sources := map[string]bool{}
+	for _, c := range report.Certificates {
+		sources[c.Source] = true
+	}
+	if !sources["caFile"] || !sources["server"] {
+		t.Errorf("certificates %+v, want caFile and server certificates", report.Certificates)
+	}
+}
+
+func TestCheckRepositoryAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "check-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(testIndex))
+	}))
+	defer ts.Close()
+	secrets.add("wrong-password")
+
+	report := checkRepository(&repo.Entry{Name: "auth", URL: ts.URL, Username: "admin", Password: "wrong-password"})
+	checkStages(t, report, map[string]string{"dns": checkOK, "tls": checkSkipped, "auth": checkFailed, "index": checkSkipped})
+	if report.OK {
+		t.Error("report is ok with a wrong password")
+	}
+
+	report = checkRepository(&repo.Entry{Name: "auth", URL: ts.URL, Username: "admin", Password: "check-password"})
+	checkStages(t, report, map[string]string{"auth": checkOK, "index": checkOK})
+	if report.Index == nil || report.Index.ChartCount != 1 || report.Index.VersionCount != 2 || report.Index.SizeBytes != len(testIndex) {
+		t.Errorf("index %+v, want 1 chart with 2 versions", report.Index)
+	}
+}
+
+func TestCheckRepositoryIndexFailure(t *testing.T) {
+	for name, tt := range map[string]struct {
+		status int
+		body   string
+	}{
+		"invalid yaml":   {http.StatusOK, "apiVersion: [v1"},
+		"no api version": {http.StatusOK, "entries: {}\n"},
+		"missing index":  {http.StatusNotFound, "not found"},
+		"server error":   {http.StatusInternalServerError, ""},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		report := checkRepository(&repo.Entry{Name: "index", URL: ts.URL})
+		ts.Close()
+
+		if report.OK || report.Index != nil {
+			t.Errorf("%s: report is ok or has index info", name)
+		}
+		got := stageStatus(report)
+		if got["auth"] != checkOK || got["index"] != checkFailed {
+			t.Errorf("%s: stages %+v, want auth ok and index failed", name, report.Stages)
+		}
+	}
+}
+
+func TestCheckRepositoryCertificateExpiry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIndex))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "helm-proxy-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name                 string
+		notAfter             time.Time
+		expired, expiresSoon bool
+	}{
+		{"valid", time.Now().Add(90 * 24 * time.Hour), false, false},
+		{"soon", time.Now().Add(10*24*time.Hour + time.Hour), false, true},
+		{"expired", time.Now().Add(-24 * time.Hour), true, false},
+	}
+	for _, tt := range tests {
+		caFile := writeTestCert(t, dir, tt.name, tt.notAfter)
+		report := checkRepository(&repo.Entry{Name: tt.name, URL: ts.URL, CAFile: caFile})
+		if len(report.Certificates) != 1 {
+			t.Fatalf("%s: certificates %+v, want the caFile certificate", tt.name, report.Certificates)
+		}
+		c := report.Certificates[0]
+		if c.Source != "caFile" || c.Subject != "CN="+tt.name || c.Expired != tt.expired || c.ExpiresSoon != tt.expiresSoon {
+			t.Errorf("%s: certificate %+v, want expired %v expires_soon %v", tt.name, c, tt.expired, tt.expiresSoon)
+		}
+	}
+	if c := checkRepository(&repo.Entry{Name: "soon", URL: ts.URL, CAFile: filepath.Join(dir, "soon.pem")}).Certificates[0]; c.DaysRemaining != 10 {
+		t.Errorf("days remaining is %d, want 10", c.DaysRemaining)
+	}
+}
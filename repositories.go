@@ -1,36 +1,40 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/gin-gonic/gin"
-	"github.com/gofrs/flock"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/cmd/helm/search"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/helmpath"
 	"helm.sh/helm/v3/pkg/repo"
-	"sigs.k8s.io/yaml"
 )
 
 const searchMaxScore = 25
 
 type repoChartElement struct {
 	Name        string   `json:"name"`
+	Repo        string   `json:"repo"`
 	Version     string   `json:"version"`
 	AppVersion  string   `json:"app_version"`
 	Description string   `json:"description"`
 	Icon        string   `json:"icon"`
 	Tags        string   `json:"tags"`
+	Keywords    []string `json:"keywords"`
+	Maintainers []string `json:"maintainers"`
+	Type        string   `json:"type"`
+	Deprecated  bool     `json:"deprecated"`
 	Url         []string `json:"url"`
 }
 
@@ -65,77 +69,88 @@ func applyConstraint(version string, versions bool, res []*search.Result) ([]*se
 }
 
 func buildSearchIndex(version string) (*search.Index, error) {
-	i := search.NewIndex()
-	for _, re := range helmConfig.HelmRepos {
-		n := re.Name
-		f := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(n))
-		ind, err := repo.LoadIndexFile(f)
-		if err != nil {
-			glog.Warningf("WARNING: Repo %q is corrupt or missing. Try 'helm repo update'.", n)
-			continue
-		}
-
-		i.AddRepo(n, ind, len(version) > 0)
-	}
-	return i, nil
+	return searchCache.get(len(version) > 0), nil
 }
 
 func initRepository(c *repo.Entry) error {
-	// Ensure the file directory exists as it is required for file locking
-	err := os.MkdirAll(filepath.Dir(settings.RepositoryConfig), os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return err
-	}
-
-	// Acquire a file lock for process synchronization
-	fileLock := flock.New(strings.Replace(settings.RepositoryConfig, filepath.Ext(settings.RepositoryConfig), ".lock", 1))
-	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
-	if err == nil && locked {
-		defer fileLock.Unlock()
-	}
-	if err != nil {
-		return err
-	}
-
-	b, err := ioutil.ReadFile(settings.RepositoryConfig)
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	var f repo.File
-	if err := yaml.Unmarshal(b, &f); err != nil {
-		return err
+	// OCI镜像仓库没有index.yaml
+	if isOCIReference(c.URL) {
+		return nil
 	}
 
 	r, err := repo.NewChartRepository(c, getter.All(settings))
 	if err != nil {
 		return err
 	}
+	r.CachePath = settings.RepositoryCache
 
 	if _, err := r.DownloadIndexFile(); err != nil {
 		return err
 	}
 
-	f.Update(c)
-
-	if err := f.WriteFile(settings.RepositoryConfig, 0644); err != nil {
-		return err
-	}
-
 	return nil
 }
 
 // @Summary 		查找chart/列出本地库中的所有chart
-// @Description 	在本地库中查找chart，如没有keyword则列出所有chart
+// @Description 	在本地库中查找chart，如没有keyword则列出所有chart，支持按仓库、关键字、维护者、appVersion、类型、是否废弃过滤
 // @Tags			Repository
 // @Param 			keyword query string false "搜索关键字"
 // @Param   		version query string false "chart版本"
 // @Param   		versions query bool false "如果true，查询出每个chart的所有版本；false，只列出每个chart最新版"
+// @Param   		repo query string false "仓库名称，repo1,repo2..."
+// @Param   		keywords query string false "Chart.yaml中的keywords，keyword1,keyword2...，需全部包含"
+// @Param   		maintainer query string false "维护者名称或邮箱"
+// @Param   		app_version query string false "appVersion范围，如>=1.0.0, <2.0.0"
+// @Param   		type query string false "Enums(application, library)"
+// @Param   		deprecated query bool false "true只列出已废弃chart；false只列出未废弃chart；不传则都列出"
 // @Success 		200 {object} respBody
 // @Router 			/repos/charts [get]
 func listRepoCharts(c *gin.Context) {
+	chartList, _, err := searchRepoCharts(c)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	respOK(c, chartList)
+}
+
+// listRepoChartsPage v2搜索chart，返回分页结果和分面统计
+func listRepoChartsPage(c *gin.Context) {
+	page, pageSize, err := pagination(c)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	chartList, facets, err := searchRepoCharts(c)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+
+	result := repoChartSearchResult{
+		Total:    len(chartList),
+		Page:     page,
+		PageSize: pageSize,
+		Facets:   facets,
+	}
+	if pageSize > 0 {
+		from := (page - 1) * pageSize
+		if from > len(chartList) {
+			from = len(chartList)
+		}
+		to := from + pageSize
+		if to > len(chartList) {
+			to = len(chartList)
+		}
+		chartList = chartList[from:to]
+	}
+	result.Charts = chartList
+
+	respOK(c, result)
+}
+
+// searchRepoCharts 按请求参数搜索并过滤chart，同时统计过滤后结果的分面
+func searchRepoCharts(c *gin.Context) (repoChartList, *repoChartFacets, error) {
 	version := c.Query("version")   // chart version
 	versions := c.Query("versions") // if "true", all versions
 	keyword := c.Query("keyword")   // search keyword
@@ -145,10 +160,14 @@ func listRepoCharts(c *gin.Context) {
 		version = ">0.0.0"
 	}
 
+	filter, err := newRepoChartFilter(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	index, err := buildSearchIndex(version)
 	if err != nil {
-		respErr(c, err)
-		return
+		return nil, nil, err
 	}
 
 	var res []*search.Result
@@ -156,6 +175,9 @@ func listRepoCharts(c *gin.Context) {
 		res = index.All()
 	} else {
 		res, err = index.Search(keyword, searchMaxScore, true)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	search.SortScore(res)
@@ -165,28 +187,301 @@ func listRepoCharts(c *gin.Context) {
 	}
 	data, err := applyConstraint(version, versionsB, res)
 	if err != nil {
-		respErr(c, err)
-		return
+		return nil, nil, err
 	}
+
 	chartList := make(repoChartList, 0, len(data))
+	facets := newRepoChartFacets()
 	for _, v := range data {
-		chartList = append(chartList, repoChartElement{
-			Name:        v.Name,
-			Version:     v.Chart.Version,
-			AppVersion:  v.Chart.AppVersion,
-			Description: v.Chart.Description,
-			Icon:        v.Chart.Icon,
-			Tags:        v.Chart.Tags,
-			Url:         v.Chart.URLs,
-		})
+		if !filter.match(v) {
+			continue
+		}
+		e := newRepoChartElement(v)
+		facets.add(&e)
+		chartList = append(chartList, e)
 	}
+	return chartList, facets, nil
+}
 
-	respOK(c, chartList)
+type repoChartSearchResult struct {
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Charts   repoChartList    `json:"charts"`
+	Facets   *repoChartFacets `json:"facets"`
+}
+
+// 搜索结果中各过滤条件的取值及对应的chart数量
+type repoChartFacets struct {
+	Repo        map[string]int `json:"repo"`
+	Keywords    map[string]int `json:"keywords"`
+	Maintainers map[string]int `json:"maintainers"`
+	Type        map[string]int `json:"type"`
+	Deprecated  map[string]int `json:"deprecated"`
+}
+
+func newRepoChartFacets() *repoChartFacets {
+	return &repoChartFacets{
+		Repo:        map[string]int{},
+		Keywords:    map[string]int{},
+		Maintainers: map[string]int{},
+		Type:        map[string]int{},
+		Deprecated:  map[string]int{},
+	}
+}
+
+func (f *repoChartFacets) add(e *repoChartElement) {
+	f.Repo[e.Repo]++
+	for _, k := range e.Keywords {
+		f.Keywords[strings.ToLower(k)]++
+	}
+	for _, m := range e.Maintainers {
+		f.Maintainers[m]++
+	}
+	f.Type[e.Type]++
+	f.Deprecated[strconv.FormatBool(e.Deprecated)]++
+}
+
+func newRepoChartElement(v *search.Result) repoChartElement {
+	e := repoChartElement{
+		Name:        v.Name,
+		Repo:        strings.SplitN(v.Name, "/", 2)[0],
+		Version:     v.Chart.Version,
+		AppVersion:  v.Chart.AppVersion,
+		Description: v.Chart.Description,
+		Icon:        v.Chart.Icon,
+		Tags:        v.Chart.Tags,
+		Keywords:    v.Chart.Keywords,
+		Maintainers: []string{},
+		Type:        chartType(v.Chart.Type),
+		Deprecated:  v.Chart.Deprecated,
+		Url:         v.Chart.URLs,
+	}
+	if e.Keywords == nil {
+		e.Keywords = []string{}
+	}
+	for _, m := range v.Chart.Maintainers {
+		if m != nil {
+			e.Maintainers = append(e.Maintainers, m.Name)
+		}
+	}
+	return e
+}
+
+// chartType 未设置type的chart为application
+func chartType(t string) string {
+	if t == "" {
+		return "application"
+	}
+	return t
+}
+
+type repoChartFilter struct {
+	repos      map[string]bool
+	keywords   []string
+	maintainer string
+	appVersion *semver.Constraints
+	chartType  string
+	deprecated *bool
+}
+
+func newRepoChartFilter(c *gin.Context) (*repoChartFilter, error) {
+	f := &repoChartFilter{
+		maintainer: strings.ToLower(c.Query("maintainer")),
+		chartType:  c.Query("type"),
+	}
+	if repos := c.Query("repo"); repos != "" {
+		f.repos = map[string]bool{}
+		for _, r := range strings.Split(repos, ",") {
+			f.repos[r] = true
+		}
+	}
+	if keywords := c.Query("keywords"); keywords != "" {
+		for _, k := range strings.Split(keywords, ",") {
+			f.keywords = append(f.keywords, strings.ToLower(strings.TrimSpace(k)))
+		}
+	}
+	if appVersion := c.Query("app_version"); appVersion != "" {
+		constraint, err := semver.NewConstraint(appVersion)
+		if err != nil {
+			return nil, badRequestf("an invalid app_version constraint format: %v", err)
+		}
+		f.appVersion = constraint
+	}
+	if deprecated := c.Query("deprecated"); deprecated != "" {
+		b, err := strconv.ParseBool(deprecated)
+		if err != nil {
+			return nil, badRequestf("bad deprecated %s, must be true or false", deprecated)
+		}
+		f.deprecated = &b
+	}
+	return f, nil
+}
+
+func (f *repoChartFilter) match(r *search.Result) bool {
+	if f.repos != nil && !f.repos[strings.SplitN(r.Name, "/", 2)[0]] {
+		return false
+	}
+	if f.chartType != "" && chartType(r.Chart.Type) != f.chartType {
+		return false
+	}
+	if f.deprecated != nil && r.Chart.Deprecated != *f.deprecated {
+		return false
+	}
+	for _, k := range f.keywords {
+		found := false
+		for _, ck := range r.Chart.Keywords {
+			if strings.ToLower(ck) == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.maintainer != "" {
+		found := false
+		for _, m := range r.Chart.Maintainers {
+			if m != nil && (strings.ToLower(m.Name) == f.maintainer || strings.ToLower(m.Email) == f.maintainer) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.appVersion != nil {
+		v, err := semver.NewVersion(r.Chart.AppVersion)
+		if err != nil || !f.appVersion.Check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// pagination 解析page/page_size参数，page_size为0表示不分页
+func pagination(c *gin.Context) (int, int, error) {
+	page, pageSize := 1, 0
+	var err error
+	if p := c.Query("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			return 0, 0, badRequestf("bad page %s", p)
+		}
+	}
+	if p := c.Query("page_size"); p != "" {
+		if pageSize, err = strconv.Atoi(p); err != nil || pageSize < 0 {
+			return 0, 0, badRequestf("bad page_size %s", p)
+		}
+	}
+	return page, pageSize, nil
+}
+
+// artifacthub.io定义的chart变更记录注解
+const chartChangesAnnotation = "artifacthub.io/changes"
+
+type chartVersionElement struct {
+	Version      string                   `json:"version"`
+	AppVersion   string                   `json:"app_version"`
+	Created      time.Time                `json:"created"`
+	Digest       string                   `json:"digest"`
+	Deprecated   bool                     `json:"deprecated"`
+	Description  string                   `json:"description"`
+	Changes      string                   `json:"changes,omitempty"`
+	Urls         []string                 `json:"urls"`
+	Dependencies []chartDependencyElement `json:"dependencies"`
+}
+
+type chartDependencyElement struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+	Condition  string `json:"condition,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+}
+
+// @Summary 		列出chart的所有版本
+// @Description 	根据仓库index列出chart的所有版本，按semver从新到旧排序
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Param 			chart path string true "chart名称"
+// @Success 		200 {object} respBody
+// @Router 			/repos/{repo}/charts/{chart}/versions [get]
+func listChartVersions(c *gin.Context) {
+	repoName := c.Param("repo")
+	name := c.Param("chart")
+
+	if !repositories.has(repoName) {
+		respErr(c, notFoundf("no repo named %q found", repoName))
+		return
+	}
+	ind, ok := searchCache.indexFile(repoName)
+	if !ok {
+		respErr(c, errors.Errorf("repo %q is corrupt or missing, try to update it", repoName))
+		return
+	}
+	chartVersions, ok := ind.Entries[name]
+	if !ok || len(chartVersions) == 0 {
+		respErr(c, notFoundf("chart %q not found in repo %s", name, repoName))
+		return
+	}
+
+	versions := make([]chartVersionElement, 0, len(chartVersions))
+	for _, cv := range sortChartVersions(chartVersions) {
+		e := chartVersionElement{
+			Version:      cv.Version,
+			AppVersion:   cv.AppVersion,
+			Created:      cv.Created,
+			Digest:       cv.Digest,
+			Deprecated:   cv.Deprecated,
+			Description:  cv.Description,
+			Changes:      cv.Annotations[chartChangesAnnotation],
+			Urls:         cv.URLs,
+			Dependencies: []chartDependencyElement{},
+		}
+		for _, d := range cv.Dependencies {
+			e.Dependencies = append(e.Dependencies, chartDependencyElement{
+				Name:       d.Name,
+				Version:    d.Version,
+				Repository: d.Repository,
+				Condition:  d.Condition,
+				Alias:      d.Alias,
+			})
+		}
+		versions = append(versions, e)
+	}
+
+	respOK(c, versions)
+}
+
+// sortChartVersions 按semver从新到旧排序，不合法的版本排在最后
+func sortChartVersions(versions repo.ChartVersions) repo.ChartVersions {
+	sorted := make(repo.ChartVersions, len(versions))
+	copy(sorted, versions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, erri := semver.NewVersion(sorted[i].Version)
+		vj, errj := semver.NewVersion(sorted[j].Version)
+		if erri != nil || errj != nil {
+			return erri == nil
+		}
+		return vi.GreaterThan(vj)
+	})
+	return sorted
 }
 
 type repositoryElement struct {
 	Name string `json:"name"`
 	URL  string `json:"url"`
+	Auth bool   `json:"auth"` // 是否配置了认证信息
+}
+
+func newRepositoryElement(re *repoConfig) repositoryElement {
+	return repositoryElement{
+		Name: re.Name,
+		URL:  secrets.mask(re.URL),
+		Auth: re.Username != "" || re.Password != "" || re.CertFile != "",
+	}
 }
 
 // @Summary 		获取所有本地库
@@ -195,17 +490,10 @@ type repositoryElement struct {
 // @Success 		200 {object} respBody
 // @Router 			/repos [get]
 func listRepositories(c *gin.Context) {
-	f, err := repo.LoadFile(settings.RepositoryConfig)
-	if os.IsNotExist(err) || (len(f.Repositories) == 0) {
-		respErr(c, err)
-	}
-
-	repoList := make([]repositoryElement, 0, len(f.Repositories))
-	for _, re := range f.Repositories {
-		repoList = append(repoList, repositoryElement{
-			Name: re.Name,
-			URL:  re.URL,
-		})
+	repos := repositories.all()
+	repoList := make([]repositoryElement, 0, len(repos))
+	for _, re := range repos {
+		repoList = append(repoList, newRepositoryElement(re))
 	}
 
 	respOK(c, repoList)
@@ -220,104 +508,240 @@ func listRepositories(c *gin.Context) {
 func addRepository(c *gin.Context) {
 	var info repoAddOptions
 	if c.Bind(&info) != nil {
-		respErr(c, errors.Errorf("missing parameters"))
+		respErr(c, badRequestf("missing parameters"))
+		return
+	}
+	setAuditRepo(c, info.Name)
+	if err := checkCredentialSources(info.UsernameFrom, info.PasswordFrom); err != nil {
+		respErr(c, err)
 		return
 	}
 
 	o := info
-	o.repoFile = settings.RepositoryConfig
 	o.repoCache = settings.RepositoryCache
 
-	//Ensure the file directory exists as it is required for file locking
-	err := os.MkdirAll(filepath.Dir(o.repoFile), os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		respErr(c, err)
+	if o.NoUpdate && repositories.has(o.Name) {
+		respErr(c, conflictf("repository name (%s) already exists, please specify a different name", o.Name))
 		return
 	}
 
-	// Acquire a file lock for process synchronization
-	fileLock := flock.New(strings.Replace(o.repoFile, filepath.Ext(o.repoFile), ".lock", 1))
-	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
-	if err == nil && locked {
-		defer fileLock.Unlock()
-	}
-	if err != nil {
+	other := o.entry()
+	if err := other.resolveCredentials(); err != nil {
 		respErr(c, err)
 		return
 	}
-
-	b, err := ioutil.ReadFile(o.repoFile)
-	if err != nil && !os.IsNotExist(err) {
-		respErr(c, err)
+	if other.Username != "" && other.Password == "" {
+		respErr(c, badRequestf("missing password"))
 		return
 	}
 
-	var f repo.File
-	if err := yaml.Unmarshal(b, &f); err != nil {
+	if !isOCIReference(other.URL) {
+		r, err := repo.NewChartRepository(&other.Entry, getter.All(settings))
+		if err != nil {
+			respErr(c, err)
+			return
+		}
+
+		if o.repoCache != "" {
+			r.CachePath = o.repoCache
+		}
+		if _, err := r.DownloadIndexFile(); err != nil {
+			// 附带分步检查结果，便于定位是DNS、TLS、认证还是index的问题
+			respErrData(c, errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", o.Url), checkRepository(&other.Entry))
+			return
+		}
+	}
+
+	if err := repositories.put(other); err != nil {
 		respErr(c, err)
 		return
 	}
+	refresher.record(other.Name, indexChartCount(other.Name), nil)
+	respOK(c, o.Name+" has been added to your repositories\n")
+}
 
-	if o.NoUpdate && f.Has(o.Name) {
-		respErr(c, errors.Errorf("repository name (%s) already exists, please specify a different name", o.Name))
-		return
+type repoAddOptions struct {
+	Name     string `json:"name"`
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	NoUpdate bool   `json:"noUpdate"`
+
+	CertFile              string `json:"certFile"`
+	KeyFile               string `json:"keyFile"`
+	CaFile                string `json:"caFile"`
+	InsecureSkipTLSverify bool   `json:"insecureSkipTLSverify"`
+	PassCredentialsAll    bool   `json:"passCredentialsAll"`
+
+	// 认证信息的外部来源只能在config.yaml中配置，API传入时拒绝
+	UsernameFrom *credentialSource `json:"usernameFrom" swaggerignore:"true"`
+	PasswordFrom *credentialSource `json:"passwordFrom" swaggerignore:"true"`
+
+	repoCache string
+}
+
+// checkCredentialSources 不允许调用方指定从服务端的环境变量、文件或Secret读取认证信息
+func checkCredentialSources(usernameFrom, passwordFrom *credentialSource) error {
+	if usernameFrom != nil || passwordFrom != nil {
+		return badRequestf("usernameFrom/passwordFrom can only be set in config.yaml")
 	}
+	return nil
+}
 
-	if o.Username != "" && o.Password == "" {
-		respErr(c, errors.Errorf("missing password"))
-		return
+func (o *repoAddOptions) entry() *repoConfig {
+	return &repoConfig{
+		Entry: repo.Entry{
+			Name:                  o.Name,
+			URL:                   o.Url,
+			Username:              o.Username,
+			Password:              o.Password,
+			CertFile:              o.CertFile,
+			KeyFile:               o.KeyFile,
+			CAFile:                o.CaFile,
+			InsecureSkipTLSverify: o.InsecureSkipTLSverify,
+		},
+		PassCredentialsAll: o.PassCredentialsAll,
 	}
+}
+
+// repoEditOptions 只修改传入的字段
+type repoEditOptions struct {
+	Url                   *string `json:"url"`
+	Username              *string `json:"username"`
+	Password              *string `json:"password"`
+	CertFile              *string `json:"certFile"`
+	KeyFile               *string `json:"keyFile"`
+	CaFile                *string `json:"caFile"`
+	InsecureSkipTLSverify *bool   `json:"insecureSkipTLSverify"`
+	PassCredentialsAll    *bool   `json:"passCredentialsAll"`
+
+	UsernameFrom *credentialSource `json:"usernameFrom" swaggerignore:"true"`
+	PasswordFrom *credentialSource `json:"passwordFrom" swaggerignore:"true"`
+}
 
-	other := repo.Entry{
-		Name:                  o.Name,
-		URL:                   o.Url,
-		Username:              o.Username,
-		Password:              o.Password,
-		CertFile:              o.CertFile,
-		KeyFile:               o.KeyFile,
-		CAFile:                o.CaFile,
-		InsecureSkipTLSverify: o.InsecureSkipTLSverify,
+func (o *repoEditOptions) apply(e *repoConfig) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
 	}
+	set(&e.URL, o.Url)
+	set(&e.Username, o.Username)
+	set(&e.Password, o.Password)
+	// 直接传入的认证信息代替config.yaml中配置的外部来源
+	if o.Username != nil {
+		e.UsernameFrom = nil
+	}
+	if o.Password != nil {
+		e.PasswordFrom = nil
+	}
+	set(&e.CertFile, o.CertFile)
+	set(&e.KeyFile, o.KeyFile)
+	set(&e.CAFile, o.CaFile)
+	if o.InsecureSkipTLSverify != nil {
+		e.InsecureSkipTLSverify = *o.InsecureSkipTLSverify
+	}
+	if o.PassCredentialsAll != nil {
+		e.PassCredentialsAll = *o.PassCredentialsAll
+	}
+}
 
-	r, err := repo.NewChartRepository(&other, getter.All(settings))
-	if err != nil {
+// @Summary			修改chart镜像库
+// @Description 	修改已添加仓库的地址、认证信息、TLS选项，只修改传入的字段；修改后会下载index验证，验证失败则不做修改
+// @Tags			Repository
+// @Param 			repo path string true "仓库名称"
+// @Param           repoinfo body repoEditOptions true "需要修改的仓库信息"
+// @Success 		200 {object} respBody
+// @Router 			/repos/{repo} [put]
+func editRepository(c *gin.Context) {
+	name := c.Param("repo")
+	var o repoEditOptions
+	if c.BindJSON(&o) != nil {
+		respErr(c, badRequestf("missing parameters"))
+		return
+	}
+	if err := checkCredentialSources(o.UsernameFrom, o.PasswordFrom); err != nil {
 		respErr(c, err)
 		return
 	}
 
-	if o.repoCache != "" {
-		r.CachePath = o.repoCache
+	// 与仓库更新互斥，避免验证期间index被覆盖
+	l, _ := repoUpdateLocks.LoadOrStore(name, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	defer l.(*sync.Mutex).Unlock()
+
+	old, ok := repositories.get(name)
+	if !ok {
+		respErr(c, notFoundf("no repo named %q found", name))
+		return
 	}
-	if _, err := r.DownloadIndexFile(); err != nil {
-		respErr(c, errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", o.Url))
+	e := *old
+	o.apply(&e)
+	if err := e.resolveCredentials(); err != nil {
+		respErr(c, err)
+		return
+	}
+	if e.Username != "" && e.Password == "" {
+		respErr(c, badRequestf("missing password"))
+		return
+	}
+	if isOCIReference(e.URL) != isOCIReference(old.URL) {
+		respErr(c, badRequestf("cannot change repository %s between oci registry and chart repository", name))
 		return
 	}
 
-	f.Update(&other)
+	// 先下载到临时目录验证，成功后再替换缓存中的index，失败时原有配置和index都不变
+	var tmp string
+	if !isOCIReference(e.URL) {
+		var err error
+		tmp, err = ioutil.TempDir("", "helm-repo-")
+		if err != nil {
+			respErr(c, err)
+			return
+		}
+		defer os.RemoveAll(tmp)
+
+		r, err := repo.NewChartRepository(&e.Entry, getter.All(settings))
+		if err != nil {
+			respErr(c, err)
+			return
+		}
+		r.CachePath = tmp
+		if _, err := r.DownloadIndexFile(); err != nil {
+			respErrData(c, errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", e.URL), checkRepository(&e.Entry))
+			return
+		}
+	}
 
-	if err := f.WriteFile(o.repoFile, 0644); err != nil {
+	if err := repositories.put(&e); err != nil {
 		respErr(c, err)
 		return
 	}
-	respOK(c, o.Name+" has been added to your repositories\n")
+	if tmp != "" {
+		for _, f := range []string{helmpath.CacheIndexFile(name), helmpath.CacheChartsFile(name)} {
+			if err := moveFile(filepath.Join(tmp, f), filepath.Join(settings.RepositoryCache, f)); err != nil {
+				glog.Warningf("failed to update cache of repo %s: %v", name, err)
+			}
+		}
+		searchCache.invalidate()
+	}
+	refresher.record(name, indexChartCount(name), nil)
+	respOK(c, name+" has been updated\n")
 }
 
-type repoAddOptions struct {
-	Name     string `json:"name"`
-	Url      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	NoUpdate bool   `json:"noUpdate"`
-
-	CertFile              string `json:"certFile"`
-	KeyFile               string `json:"keyFile"`
-	CaFile                string `json:"caFile"`
-	InsecureSkipTLSverify bool   `json:"insecureSkipTLSverify"`
-
-	repoFile  string
-	repoCache string
+// moveFile 临时目录可能与缓存目录不在同一文件系统，rename失败时复制
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0644)
 }
 
 // @Summary			删除chart镜像库
@@ -329,28 +753,21 @@ type repoAddOptions struct {
 func removeRepository(c *gin.Context) {
 	reponame := c.Param("reponame")
 	if reponame == "" {
-		respErr(c, fmt.Errorf("chart name can not be empty"))
+		reponame = c.Param("repo")
+	}
+	if reponame == "" {
+		respErr(c, badRequestf("chart name can not be empty"))
 		return
 	}
 	names := strings.Split(reponame, ",")
 
-	r, err := repo.LoadFile(settings.RepositoryConfig)
-	if os.IsNotExist(err) || len(r.Repositories) == 0 {
-		respErr(c, fmt.Errorf("no repositories configured"))
+	if err := repositories.remove(names...); err != nil {
+		respErr(c, err)
 		return
 	}
 
 	msg := ""
 	for _, name := range names {
-		if !r.Remove(name) {
-			respErr(c, fmt.Errorf("no repo named %q found", name))
-			return
-		}
-		if err := r.WriteFile(settings.RepositoryConfig, 0644); err != nil {
-			respErr(c, err)
-			return
-		}
-
 		if err := removeRepoCache(settings.RepositoryCache, name); err != nil {
 			respErr(c, err)
 			return
@@ -377,46 +794,149 @@ func removeRepoCache(root, name string) error {
 }
 
 // @Summary			更新chart镜像库
-// @Description 	更新chart仓库信息
+// @Description 	更新chart仓库的index，不指定repos时更新全部仓库，返回每个仓库的更新结果
 // @Tags			Repository
+// @Param 			repos query string false "repo1,repo2,repo3..."
 // @Success 		200 {object} respBody
 // @Router 			/repos/update [put]
 func updateRepositories(c *gin.Context) {
-	type errRepo struct {
-		Name string
-		Err  string
+	var repos []*repoConfig
+	if names := c.Query("repos"); names != "" {
+		setAuditRepo(c, names)
+		for _, name := range strings.Split(names, ",") {
+			e, ok := repositories.get(name)
+			if !ok {
+				respErr(c, notFoundf("no repo named %q found", name))
+				return
+			}
+			repos = append(repos, e)
+		}
+	} else {
+		repos = repositories.all()
+	}
+
+	results := updateRepositoryList(repos)
+
+	failed := []string{}
+	for _, r := range results {
+		if r.Status != repoUpdateOK {
+			failed = append(failed, r.Name)
+		}
 	}
-	errRepoList := []errRepo{}
+	if len(failed) > 0 {
+		respErrData(c, fmt.Errorf("failed to update repositories: %s", strings.Join(failed, ", ")), results)
+		return
+	}
+
+	respOK(c, results)
+}
+
+const (
+	repoUpdateOK     = "ok"
+	repoUpdateFailed = "failed"
+)
+
+type repoUpdateResult struct {
+	Name            string   `json:"name"`
+	Status          string   `json:"status"` // ok or failed
+	Error           string   `json:"error,omitempty"`
+	Duration        string   `json:"duration"`
+	ChartCount      int      `json:"chart_count"`
+	ChartCountDelta int      `json:"chart_count_delta"`
+	NewVersions     []string `json:"new_versions"` // chart-version
+}
+
+// updateRepositoryList 并发更新仓库，结果顺序与repos一致
+func updateRepositoryList(repos []*repoConfig) []repoUpdateResult {
+	results := make([]repoUpdateResult, len(repos))
 
 	var wg sync.WaitGroup
-	for _, c := range helmConfig.HelmRepos {
+	for i, e := range repos {
 		wg.Add(1)
-		go func(c *repo.Entry) {
+		go func(i int, e *repoConfig) {
 			defer wg.Done()
-			err := updateCharts(c)
-			if err != nil {
-				errRepoList = append(errRepoList, errRepo{
-					Name: c.Name,
-					Err:  err.Error(),
-				})
-			}
-		}(c)
+			results[i] = updateRepository(&e.Entry)
+		}(i, e)
 	}
 	wg.Wait()
 
-	if len(errRepoList) > 0 {
-		respErr(c, fmt.Errorf("error list: %v", errRepoList))
-		return
+	return results
+}
+
+// 同一仓库的index同时只允许一个下载，避免手动更新与定时刷新同时写index文件
+var repoUpdateLocks sync.Map
+
+// updateRepository 下载仓库index，并与之前的index比较出新增的chart版本
+func updateRepository(e *repo.Entry) repoUpdateResult {
+	result := repoUpdateResult{Name: e.Name, NewVersions: []string{}}
+	start := time.Now()
+
+	l, _ := repoUpdateLocks.LoadOrStore(e.Name, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	defer l.(*sync.Mutex).Unlock()
+
+	indexFile := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(e.Name))
+	before := map[string]bool{}
+	if old, err := repo.LoadIndexFile(indexFile); err == nil {
+		result.ChartCountDelta = -len(old.Entries)
+		for name, versions := range old.Entries {
+			for _, v := range versions {
+				before[name+"-"+v.Version] = true
+			}
+		}
+	}
+
+	err := updateCharts(e)
+	searchCache.invalidate()
+	result.Duration = time.Since(start).String()
+	if err != nil {
+		refresher.record(e.Name, 0, err)
+		result.Status = repoUpdateFailed
+		result.Error = secrets.mask(err.Error())
+		result.ChartCountDelta = 0
+		return result
 	}
+	result.Status = repoUpdateOK
 
-	respOK(c, nil)
+	if isOCIReference(e.URL) {
+		refresher.record(e.Name, 0, nil)
+		result.ChartCountDelta = 0
+		return result
+	}
+	// 新的index只解析一次，chart数量也用于刷新状态
+	ind, err := repo.LoadIndexFile(indexFile)
+	if err != nil {
+		refresher.record(e.Name, 0, err)
+		result.Status = repoUpdateFailed
+		result.Error = secrets.mask(err.Error())
+		result.ChartCountDelta = 0
+		return result
+	}
+	refresher.record(e.Name, len(ind.Entries), nil)
+	result.ChartCount = len(ind.Entries)
+	result.ChartCountDelta += len(ind.Entries)
+	for name, versions := range ind.Entries {
+		for _, v := range versions {
+			if !before[name+"-"+v.Version] {
+				result.NewVersions = append(result.NewVersions, name+"-"+v.Version)
+			}
+		}
+	}
+	sort.Strings(result.NewVersions)
+
+	return result
 }
 
 func updateCharts(c *repo.Entry) error {
+	if isOCIReference(c.URL) {
+		return nil
+	}
 	r, err := repo.NewChartRepository(c, getter.All(settings))
 	if err != nil {
 		return err
 	}
+	// 与读取index的位置一致(--repository-cache)
+	r.CachePath = settings.RepositoryCache
 	_, err = r.DownloadIndexFile()
 	if err != nil {
 		return err
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestRepositoryAPIRejectsCredentialSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	body := `{"name":"private","url":"https://charts.example.com/private","username":"admin","passwordFrom":{"file":"/etc/shadow"}}`
+	for _, r := range []struct{ method, path string }{
+		{http.MethodPost, "/api/repos/add"},
+		{http.MethodPost, "/api/repos/check"},
+		{http.MethodPut, "/api/repos/stable"},
+		{http.MethodPost, "/api/v2/repos"},
+		{http.MethodPost, "/api/v2/repos/check"},
+		{http.MethodPut, "/api/v2/repos/stable"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		if !strings.Contains(w.Body.String(), "can only be set in config.yaml") {
+			t.Errorf("%s %s accepted a credential source: %d %s", r.method, r.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUpdateRepositoriesPartialFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	index, err := ioutil.ReadFile(filepath.Join("testdata", "index.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/good/index.yaml" {
+			w.Write(index)
+			return
+		}
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "helm-proxy-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldCache, oldRepos := settings.RepositoryCache, repositories.repos
+	defer func() { settings.RepositoryCache, repositories.repos = oldCache, oldRepos }()
+	settings.RepositoryCache = dir
+
+	// 地址中带认证信息和token的仓库更新失败，结果中不能出现这些信息
+	secrets.add("path-token-1234")
+	badURL := strings.Replace(server.URL, "http://", "http://admin:url-password@", 1) + "/path-token-1234"
+	repositories.repos = []*repoConfig{
+		{Entry: repo.Entry{Name: "good", URL: server.URL + "/good"}},
+		{Entry: repo.Entry{Name: "bad", URL: badURL}},
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/repos/update", nil)
+	updateRepositories(c)
+
+	var body struct {
+		Code  int                `json:"code"`
+		Error string             `json:"error"`
+		Data  []repoUpdateResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != 1 || !strings.Contains(body.Error, "bad") || len(body.Data) != 2 {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	good, bad := body.Data[0], body.Data[1]
+	if good.Name != "good" || good.Status != repoUpdateOK || good.ChartCount != 40 || good.Error != "" {
+		t.Errorf("unexpected result of good: %+v", good)
+	}
+	if bad.Name != "bad" || bad.Status != repoUpdateFailed || bad.Error == "" {
+		t.Errorf("unexpected result of bad: %+v", bad)
+	}
+	for _, leaked := range []string{"url-password", "path-token-1234"} {
+		if strings.Contains(w.Body.String(), leaked) {
+			t.Errorf("%q leaks into the response: %s", leaked, w.Body.String())
+		}
+	}
+}
+
+func getCharts(t *testing.T, router *gin.Engine, path string, data interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	body := respBody{Data: data}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: %v %s", path, err, w.Body.String())
+	}
+	if body.Code != 0 && w.Code == http.StatusOK {
+		return http.StatusInternalServerError
+	}
+	return w.Code
+}
+
+func TestListRepoChartsFilters(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	tests := []struct {
+		query string
+		count int
+		match func(e repoChartElement) bool
+	}{
+		{"", 40, func(repoChartElement) bool { return true }},
+		{"keywords=ingress", 5, func(e repoChartElement) bool { return hasString(e.Keywords, "ingress") }},
+		{"keywords=INGRESS,queue", 2, func(e repoChartElement) bool {
+			return hasString(e.Keywords, "ingress") && hasString(e.Keywords, "queue")
+		}},
+		{"keywords=queue,metrics", 0, nil},
+		{"maintainer=team-1", 8, func(e repoChartElement) bool { return hasString(e.Maintainers, "team-1") }},
+		{"maintainer=team1@example.com", 8, func(e repoChartElement) bool { return hasString(e.Maintainers, "team-1") }},
+		{"app_version=" + url.QueryEscape(">=4.0.0, <5.0.0"), 10, func(e repoChartElement) bool { return strings.HasPrefix(e.AppVersion, "4.") }},
+		{"type=library", 4, func(e repoChartElement) bool { return e.Type == "library" }},
+		{"type=application", 36, func(e repoChartElement) bool { return e.Type == "application" }},
+		{"deprecated=true", 1, func(e repoChartElement) bool { return e.Deprecated && e.Name == "stable/chart-39" }},
+		{"deprecated=false", 39, func(e repoChartElement) bool { return !e.Deprecated }},
+		{"versions=true&type=library", 12, func(e repoChartElement) bool { return e.Type == "library" }},
+		{"repo=other", 0, nil},
+	}
+	for _, tt := range tests {
+		// v1返回chart数组
+		var list repoChartList
+		if code := getCharts(t, router, "/api/repos/charts?"+tt.query, &list); code != http.StatusOK {
+			t.Fatalf("v1 %q: status %d", tt.query, code)
+		}
+		if len(list) != tt.count {
+			t.Errorf("v1 %q: got %d charts, want %d", tt.query, len(list), tt.count)
+		}
+		for _, e := range list {
+			if !tt.match(e) {
+				t.Errorf("v1 %q: unexpected chart %+v", tt.query, e)
+			}
+		}
+
+		// v2返回相同的过滤结果
+		var result repoChartSearchResult
+		if code := getCharts(t, router, "/api/v2/charts?"+tt.query, &result); code != http.StatusOK {
+			t.Fatalf("v2 %q: status %d", tt.query, code)
+		}
+		if result.Total != tt.count || len(result.Charts) != tt.count {
+			t.Errorf("v2 %q: got total %d and %d charts, want %d", tt.query, result.Total, len(result.Charts), tt.count)
+		}
+	}
+
+	for _, query := range []string{"deprecated=maybe", "app_version=" + url.QueryEscape("~>1.x=="), "page=0", "page_size=-1"} {
+		if code := getCharts(t, router, "/api/v2/charts?"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("v2 %q: status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestListRepoChartsPagination(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	// v1即使传了分页参数也返回完整数组
+	var list repoChartList
+	if code := getCharts(t, router, "/api/repos/charts?page=2&page_size=15", &list); code != http.StatusOK || len(list) != 40 {
+		t.Fatalf("v1: status %d, %d charts", code, len(list))
+	}
+
+	seen := map[string]bool{}
+	for _, tt := range []struct{ page, count int }{{1, 15}, {2, 15}, {3, 10}, {4, 0}} {
+		var result repoChartSearchResult
+		path := "/api/v2/charts?page_size=15&page=" + strconv.Itoa(tt.page)
+		if code := getCharts(t, router, path, &result); code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, code)
+		}
+		if result.Total != 40 || result.Page != tt.page || result.PageSize != 15 || len(result.Charts) != tt.count {
+			t.Errorf("%s: total %d page %d page_size %d, %d charts, want %d", path, result.Total, result.Page, result.PageSize, len(result.Charts), tt.count)
+		}
+		for _, e := range result.Charts {
+			if seen[e.Name] {
+				t.Errorf("%s: chart %s is returned on more than one page", path, e.Name)
+			}
+			seen[e.Name] = true
+		}
+		// 分面统计针对全部过滤结果，而不是当前页
+		if result.Facets == nil || result.Facets.Type["library"] != 4 || result.Facets.Deprecated["true"] != 1 || result.Facets.Repo["stable"] != 40 {
+			t.Errorf("%s: unexpected facets %+v", path, result.Facets)
+		}
+	}
+	if len(seen) != 40 {
+		t.Errorf("pages returned %d distinct charts, want 40", len(seen))
+	}
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEditRepositoryRollback(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	defer writeTestConfig(t, testConfigYAML)()
+	oldRepoConfig := settings.RepositoryConfig
+	defer func() { settings.RepositoryConfig = oldRepoConfig }()
+	settings.RepositoryConfig = filepath.Join(settings.RepositoryCache, "repositories.yaml")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/good/index.yaml" {
+			w.Write([]byte(testIndex))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+	edit := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/v2/repos/stable", strings.NewReader(`{"url":"`+url+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	indexPath := filepath.Join(settings.RepositoryCache, "stable-index.yaml")
+	oldIndex, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldConfig, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新地址的index无法下载，仓库配置、config.yaml和缓存的index都不变
+	if w := edit(server.URL + "/broken"); w.Code == http.StatusOK {
+		t.Fatalf("editing to a broken url succeeded: %s", w.Body.String())
+	}
+	if e, _ := repositories.get("stable"); e.URL != "https://charts.example.com/stable" {
+		t.Errorf("repo url is %s after a failed edit", e.URL)
+	}
+	if b, _ := ioutil.ReadFile(configFile); string(b) != string(oldConfig) {
+		t.Errorf("config.yaml changed after a failed edit:\n%s", b)
+	}
+	if b, _ := ioutil.ReadFile(indexPath); string(b) != string(oldIndex) {
+		t.Error("cached index changed after a failed edit")
+	}
+	if ind, ok := searchCache.indexFile("stable"); !ok || len(ind.Entries) != 40 {
+		t.Error("cached index of stable is not the original one after a failed edit")
+	}
+
+	// 验证成功后替换配置和index
+	if w := edit(server.URL + "/good"); w.Code != http.StatusOK {
+		t.Fatalf("editing to a valid url failed: %d %s", w.Code, w.Body.String())
+	}
+	if e, _ := repositories.get("stable"); e.URL != server.URL+"/good" {
+		t.Errorf("repo url is %s, want %s/good", e.URL, server.URL)
+	}
+	if _, conf := readTestConfig(t); len(conf.HelmRepos) != 1 || conf.HelmRepos[0].URL != server.URL+"/good" {
+		t.Errorf("config.yaml has repos %+v, want only stable with the new url", conf.HelmRepos)
+	}
+	if ind, ok := searchCache.indexFile("stable"); !ok || len(ind.Entries) != 1 || len(ind.Entries["nginx"]) != 2 {
+		t.Error("cached index of stable is not replaced after a successful edit")
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// repoConfig config.yaml中的仓库配置，在helm仓库信息之外增加代理自己的选项
+type repoConfig struct {
+	repo.Entry
+
+	// chart地址与仓库不在同一域名时，是否也发送仓库的认证信息
+	PassCredentialsAll bool `yaml:"pass_credentials_all" json:"pass_credentials_all"`
+	// 从环境变量、文件或k8s Secret读取认证信息，设置后不会写回config.yaml
+	UsernameFrom *credentialSource `yaml:"usernameFrom" json:"usernameFrom,omitempty"`
+	PasswordFrom *credentialSource `yaml:"passwordFrom" json:"passwordFrom,omitempty"`
+	// 是否要求校验该仓库chart的签名，未设置时使用全局provenance.verify；keyring未设置时使用全局keyring
+	Verify  *bool  `yaml:"verify" json:"verify,omitempty"`
+	Keyring string `yaml:"keyring" json:"keyring,omitempty"`
+
+	// 被HELM_PROXY_*环境变量覆盖的字段，写回config.yaml时保留文件中原来的值
+	envKeys []string
+}
+
+func (r *repoConfig) resolveCredentials() error {
+	if err := resolveCredential(&r.Username, r.UsernameFrom); err != nil {
+		return errors.Wrapf(err, "failed to resolve username of repository %s", r.Name)
+	}
+	if err := resolveCredential(&r.Password, r.PasswordFrom); err != nil {
+		return errors.Wrapf(err, "failed to resolve password of repository %s", r.Name)
+	}
+	// 检查、添加仓库时密码来自请求，保存前就需要屏蔽
+	secrets.add(r.Password)
+	return nil
+}
+
+// persisted 写回config.yaml的内容，引用外部来源的认证信息不写明文；
+// 密码只保留config.yaml中原本就有的明文，通过API添加的密码只保存在repositories.yaml
+func (r *repoConfig) persisted(original map[string]interface{}) *repoConfig {
+	p := *r
+	if p.UsernameFrom != nil {
+		p.Username = ""
+	}
+	if p.PasswordFrom != nil {
+		p.Password = ""
+	}
+	if password, _ := original["password"].(string); p.Password != password {
+		p.Password = ""
+	}
+	return &p
+}
+
+// withoutEnvOverrides 被环境变量覆盖的字段使用config.yaml中原来的值，原来没有则不写；
+// 完全由环境变量定义的仓库返回nil
+func (r *repoConfig) withoutEnvOverrides(original map[string]interface{}) (interface{}, error) {
+	if len(r.envKeys) == 0 {
+		return r, nil
+	}
+	if original == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for _, key := range r.envKeys {
+		if v, ok := original[key]; ok {
+			m[key] = v
+		} else {
+			delete(m, key)
+		}
+	}
+	return m, nil
+}
+
+// repoStore 仓库注册表，config.yaml中的helmRepos和helm的repositories.yaml都汇总到这里，
+// 通过api添加/删除/修改的仓库会同时写回这两个文件
+type repoStore struct {
+	mu    sync.RWMutex
+	repos []*repoConfig
+}
+
+var repositories = &repoStore{}
+
+// load 以config.yaml中的仓库为准，再补充repositories.yaml中额外的仓库(removed中的除外)；
+// commit在替换仓库列表时一起执行(持有仓库锁)，用于同时替换配置
+func (s *repoStore) load(configured []*repoConfig, removed map[string]bool, commit func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := repo.LoadFile(settings.RepositoryConfig)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+
+	repos := make([]*repoConfig, 0, len(configured))
+	names := map[string]bool{}
+	for _, e := range configured {
+		if names[e.Name] {
+			return errors.Errorf("repository name (%s) is duplicated in config", e.Name)
+		}
+		names[e.Name] = true
+		if f != nil {
+			fillSavedCredentials(e, f.Get(e.Name))
+		}
+		repos = append(repos, e)
+	}
+
+	if f != nil {
+		for _, e := range f.Repositories {
+			if !names[e.Name] && !removed[e.Name] {
+				names[e.Name] = true
+				repos = append(repos, &repoConfig{Entry: *e})
+			}
+		}
+	}
+
+	// 新的仓库列表写入repositories.yaml成功后才替换，失败时保留原来的仓库列表
+	if err := writeRepositoryFile(repos); err != nil {
+		return err
+	}
+	s.repos = repos
+	if commit != nil {
+		commit()
+	}
+	return nil
+}
+
+// fillSavedCredentials config.yaml中没有写明文的认证信息从repositories.yaml中同名同地址的仓库补全
+func fillSavedCredentials(e *repoConfig, saved *repo.Entry) {
+	if saved == nil || saved.URL != e.URL {
+		return
+	}
+	if e.Username == "" && e.UsernameFrom == nil {
+		e.Username = saved.Username
+	}
+	if e.Password == "" && e.PasswordFrom == nil {
+		e.Password = saved.Password
+	}
+}
+
+// all 返回所有仓库的快照
+func (s *repoStore) all() []*repoConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*repoConfig, len(s.repos))
+	copy(list, s.repos)
+	return list
+}
+
+func (s *repoStore) get(name string) (*repoConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.repos {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (s *repoStore) has(name string) bool {
+	_, ok := s.get(name)
+	return ok
+}
+
+// put 添加仓库，同名仓库则替换
+func (s *repoStore) put(e *repoConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets.add(e.Password)
+	repos := make([]*repoConfig, 0, len(s.repos)+1)
+	replaced := false
+	for _, r := range s.repos {
+		if r.Name == e.Name {
+			repos = append(repos, e)
+			replaced = true
+			continue
+		}
+		repos = append(repos, r)
+	}
+	if !replaced {
+		repos = append(repos, e)
+	}
+	return s.persist(repos)
+}
+
+// remove 删除仓库，任意一个名称不存在则都不删除
+func (s *repoStore) remove(names ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := map[string]bool{}
+	for _, name := range names {
+		removed[name] = true
+	}
+	repos := make([]*repoConfig, 0, len(s.repos))
+	for _, r := range s.repos {
+		if removed[r.Name] {
+			delete(removed, r.Name)
+			continue
+		}
+		repos = append(repos, r)
+	}
+	for name := range removed {
+		return notFoundf("no repo named %q found", name)
+	}
+	return s.persist(repos)
+}
+
+// persist 写回repositories.yaml和config.yaml，成功后才替换内存中的仓库列表
+func (s *repoStore) persist(repos []*repoConfig) error {
+	if err := writeRepositoryFile(repos); err != nil {
+		return err
+	}
+	if err := writeConfigRepos(repos); err != nil {
+		return err
+	}
+	s.repos = repos
+	searchCache.invalidate()
+	return nil
+}
+
+// writeRepositoryFile 覆盖helm的repositories.yaml
+func writeRepositoryFile(repos []*repoConfig) error {
+	// Ensure the file directory exists as it is required for file locking
+	err := os.MkdirAll(filepath.Dir(settings.RepositoryConfig), os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	// Acquire a file lock for process synchronization
+	fileLock := flock.New(strings.Replace(settings.RepositoryConfig, filepath.Ext(settings.RepositoryConfig), ".lock", 1))
+	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
+	if err == nil && locked {
+		defer fileLock.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	f := repo.NewFile()
+	for _, r := range repos {
+		e := r.Entry
+		f.Repositories = append(f.Repositories, &e)
+	}
+	// helm下载chart时从这里读取认证信息，所以仍包含解析后的密码，只允许当前用户读写
+	return f.WriteFile(settings.RepositoryConfig, 0600)
+}
+
+// writeConfigRepos 只替换config.yaml中helmRepos节点的内容，其他配置项、注释和顺序保持不变，
+// 内容没有变化的仓库沿用原来的节点
+func writeConfigRepos(repos []*repoConfig) error {
+	if configFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yamlv3.MappingNode {
+		return errors.Errorf("%s is not a yaml mapping", configFile)
+	}
+
+	var list *yamlv3.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "helmRepos" {
+			list = root.Content[i+1]
+		}
+	}
+	if list == nil {
+		list = &yamlv3.Node{}
+		root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "helmRepos"}, list)
+	}
+
+	nodes := map[string]*yamlv3.Node{}
+	original := map[string]map[string]interface{}{}
+	if list.Kind == yamlv3.SequenceNode {
+		for _, n := range list.Content {
+			var m map[string]interface{}
+			if n.Decode(&m) != nil {
+				continue
+			}
+			name, _ := m["name"].(string)
+			nodes[name] = n
+			original[name] = m
+		}
+	}
+
+	content := make([]*yamlv3.Node, 0, len(repos))
+	for _, r := range repos {
+		p, err := r.persisted(original[r.Name]).withoutEnvOverrides(original[r.Name])
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+		fields, err := repoFields(p)
+		if err != nil {
+			return err
+		}
+		if n, ok := nodes[r.Name]; ok {
+			if old, err := repoFields(original[r.Name]); err == nil && reflect.DeepEqual(old, fields) {
+				content = append(content, n)
+				continue
+			}
+		}
+		n, err := repoNode(fields)
+		if err != nil {
+			return err
+		}
+		content = append(content, n)
+	}
+	*list = yamlv3.Node{
+		Kind:        yamlv3.SequenceNode,
+		Tag:         "!!seq",
+		Content:     content,
+		HeadComment: list.HeadComment,
+		LineComment: list.LineComment,
+		FootComment: list.FootComment,
+	}
+
+	var out bytes.Buffer
+	enc := yamlv3.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	st, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+	tmp := configFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, out.Bytes(), st.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFile)
+}
+
+// repoFields 仓库配置转为json tag对应的字段，省略空值
+func repoFields(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		if v == nil || v == "" || v == false {
+			delete(m, k)
+		}
+	}
+	return m, nil
+}
+
+// repoNode 生成仓库的yaml节点，name和url在前，其余字段按名称排序
+func repoNode(fields map[string]interface{}) (*yamlv3.Node, error) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != "name" && k != "url" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	keys = append([]string{"name", "url"}, keys...)
+
+	n := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	for _, k := range keys {
+		v, ok := fields[k]
+		if !ok {
+			continue
+		}
+		var value yamlv3.Node
+		if err := value.Encode(v); err != nil {
+			return nil, err
+		}
+		n.Content = append(n.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: k}, &value)
+	}
+	return n, nil
+}
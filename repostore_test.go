@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+const testConfigYAML = `# 上传目录
+uploadPath: /tmp/charts/upload
+# 仓库
+helmRepos:
+  - name: harbor
+    url: https://harbor.example.com/chartrepo/chart
+    username: admin
+    # 密码从环境变量读取
+    passwordFrom:
+      env: HARBOR_PASSWORD
+  - name: stable
+    url: https://charts.example.com/stable
+#  - name: oci
+#    url: oci://registry.example.com/chart
+
+# 审计日志
+# audit:
+#   file: /var/log/helm-proxy/audit.log
+snapPath: /tmp/charts/snap
+`
+
+func writeTestConfig(t *testing.T, content string) func() {
+	dir, err := ioutil.TempDir("", "helm-proxy-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := configFile
+	configFile = filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		configFile = old
+		os.RemoveAll(dir)
+	}
+}
+
+func readTestConfig(t *testing.T) (string, *HelmConfig) {
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &HelmConfig{}
+	if err := yaml.Unmarshal(b, conf); err != nil {
+		t.Fatalf("config.yaml is no longer valid: %v\n%s", err, b)
+	}
+	return string(b), conf
+}
+
+func TestWriteConfigReposKeepsUnchangedFile(t *testing.T) {
+	// 使用仓库中带完整示例注释的config.yaml
+	b, err := ioutil.ReadFile("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writeTestConfig(t, string(b))()
+
+	var conf HelmConfig
+	if err := yaml.Unmarshal(b, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfigRepos(conf.HelmRepos); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readTestConfig(t); got != string(b) {
+		t.Errorf("unchanged repositories rewrote config.yaml:\n%s", got)
+	}
+}
+
+func TestWriteConfigReposKeepsComments(t *testing.T) {
+	defer writeTestConfig(t, testConfigYAML)()
+
+	var conf HelmConfig
+	if err := yaml.Unmarshal([]byte(testConfigYAML), &conf); err != nil {
+		t.Fatal(err)
+	}
+	harbor := conf.HelmRepos[0]
+	repos := []*repoConfig{
+		harbor,
+		{Entry: repo.Entry{Name: "bitnami", URL: "https://charts.example.com/bitnami", CAFile: "/etc/ca.crt"}},
+	}
+	if err := writeConfigRepos(repos); err != nil {
+		t.Fatal(err)
+	}
+
+	got, written := readTestConfig(t)
+	for _, want := range []string{"# 上传目录", "# 密码从环境变量读取", "#  - name: oci", "# 审计日志", "#   file: /var/log/helm-proxy/audit.log"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("comment %q is lost:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "uploadPath:") > strings.Index(got, "helmRepos:") || strings.Index(got, "helmRepos:") > strings.Index(got, "snapPath:") {
+		t.Errorf("top level keys are reordered:\n%s", got)
+	}
+	if strings.Contains(got, "stable") {
+		t.Errorf("removed repository is still written:\n%s", got)
+	}
+	if !strings.Contains(got, "  - name: bitnami\n    url: https://charts.example.com/bitnami\n    caFile: /etc/ca.crt\n") {
+		t.Errorf("added repository is not written with name and url first:\n%s", got)
+	}
+	if len(written.HelmRepos) != 2 || written.HelmRepos[1].CAFile != "/etc/ca.crt" || written.HelmRepos[0].PasswordFrom == nil {
+		t.Errorf("unexpected repositories after write: %+v", written.HelmRepos)
+	}
+}
+
+func TestWriteConfigReposAddsMissingKey(t *testing.T) {
+	defer writeTestConfig(t, "# 只有上传目录\nuploadPath: /tmp/charts/upload\n")()
+
+	if err := writeConfigRepos([]*repoConfig{{Entry: repo.Entry{Name: "stable", URL: "https://charts.example.com/stable"}}}); err != nil {
+		t.Fatal(err)
+	}
+	got, written := readTestConfig(t)
+	if !strings.HasPrefix(got, "# 只有上传目录\nuploadPath: /tmp/charts/upload\n") {
+		t.Errorf("existing content is changed:\n%s", got)
+	}
+	if len(written.HelmRepos) != 1 || written.HelmRepos[0].Name != "stable" {
+		t.Errorf("unexpected repositories after write: %+v", written.HelmRepos)
+	}
+}
+
+func TestWriteConfigReposKeepsAPIPasswordOut(t *testing.T) {
+	defer writeTestConfig(t, "helmRepos:\n  - name: stable\n    url: https://charts.example.com/stable\n    username: admin\n    password: in-config\n")()
+
+	repos := []*repoConfig{
+		{Entry: repo.Entry{Name: "stable", URL: "https://charts.example.com/stable", Username: "admin", Password: "in-config"}},
+		{Entry: repo.Entry{Name: "private", URL: "https://charts.example.com/private", Username: "admin", Password: "from-api"}},
+	}
+	if err := writeConfigRepos(repos); err != nil {
+		t.Fatal(err)
+	}
+	got, written := readTestConfig(t)
+	if strings.Contains(got, "from-api") {
+		t.Errorf("password added through the API is written to config.yaml:\n%s", got)
+	}
+	if len(written.HelmRepos) != 2 || written.HelmRepos[0].Password != "in-config" || written.HelmRepos[1].Username != "admin" {
+		t.Errorf("unexpected repositories after write: %+v", written.HelmRepos)
+	}
+}
+
+func TestLoadFillsSavedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old, oldRepos := settings.RepositoryConfig, repositories.repos
+	defer func() { settings.RepositoryConfig, repositories.repos = old, oldRepos }()
+	settings.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+
+	saved := []*repoConfig{
+		{Entry: repo.Entry{Name: "private", URL: "https://charts.example.com/private", Username: "admin", Password: "from-api"}},
+		{Entry: repo.Entry{Name: "moved", URL: "https://old.example.com/moved", Username: "admin", Password: "old"}},
+	}
+	if err := writeRepositoryFile(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	configured := []*repoConfig{
+		{Entry: repo.Entry{Name: "private", URL: "https://charts.example.com/private", Username: "admin"}},
+		{Entry: repo.Entry{Name: "moved", URL: "https://new.example.com/moved"}},
+	}
+	if err := repositories.load(configured, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := repositories.get("private"); e.Password != "from-api" {
+		t.Errorf("password of private = %q, want the one saved in repositories.yaml", e.Password)
+	}
+	if e, _ := repositories.get("moved"); e.Username != "" || e.Password != "" {
+		t.Errorf("credentials of a repository with a different url are reused: %+v", e.Entry)
+	}
+}
+
+func TestLoadKeepsOldStateOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old, oldRepos := settings.RepositoryConfig, repositories.repos
+	defer func() { settings.RepositoryConfig, repositories.repos = old, oldRepos }()
+
+	current := []*repoConfig{{Entry: repo.Entry{Name: "stable", URL: "https://charts.example.com/stable"}}}
+	repositories.repos = current
+	next := []*repoConfig{{Entry: repo.Entry{Name: "bitnami", URL: "https://charts.example.com/bitnami"}}}
+
+	// repositories.yaml所在目录无法创建时写入失败
+	blocker := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings.RepositoryConfig = filepath.Join(blocker, "repositories.yaml")
+	committed := false
+	if err := repositories.load(next, nil, func() { committed = true }); err == nil {
+		t.Fatal("load should fail when repositories.yaml can not be written")
+	}
+	duplicated := append(next, next[0])
+	settings.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	if err := repositories.load(duplicated, nil, func() { committed = true }); err == nil {
+		t.Fatal("load should fail with duplicated repositories")
+	}
+	if committed || len(repositories.all()) != 1 || repositories.all()[0] != current[0] {
+		t.Fatalf("state is replaced after a failed load: committed=%v, repos=%+v", committed, repositories.all())
+	}
+
+	if err := repositories.load(next, nil, func() { committed = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !committed || !repositories.has("bitnami") || repositories.has("stable") {
+		t.Errorf("state is not replaced after a successful load: committed=%v, repos=%+v", committed, repositories.all())
+	}
+}
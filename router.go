@@ -1,60 +1,179 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang/glog"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
 	_ "helm-proxy/docs"
 )
 
+// gin.Context中的键
+const (
+	ctxStatusCodesKey   = "helm-proxy/status-codes"
+	ctxSuccessStatusKey = "helm-proxy/success-status"
+)
+
 type respBody struct {
 	Code  int         `json:"code"` // 0 or 1, 0 is ok, 1 is error
 	Data  interface{} `json:"data,omitempty"`
 	Error string      `json:"error,omitempty"`
 }
 
+// apiError 带HTTP状态码的错误，v1接口仍返回200，v2接口返回该状态码
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func badRequestf(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func forbiddenf(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusForbidden, msg: fmt.Sprintf(format, args...)}
+}
+
+func notFoundf(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflictf(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorStatus 按错误类型确定HTTP状态码，依次检查被wrap的错误，无法识别的返回500
+func errorStatus(err error) int {
+	if err == nil {
+		return http.StatusInternalServerError
+	}
+	// helm没有导出这两个错误，只能按错误信息判断
+	switch msg := err.Error(); {
+	case strings.Contains(msg, "cannot re-use a name that is still in use"):
+		return http.StatusConflict
+	case strings.Contains(msg, "has no deployed releases"):
+		return http.StatusNotFound
+	}
+	for err != nil {
+		if e, ok := err.(*apiError); ok {
+			return e.status
+		}
+		switch {
+		case err == driver.ErrReleaseNotFound, apierrors.IsNotFound(err), os.IsNotExist(err):
+			return http.StatusNotFound
+		case err == driver.ErrReleaseExists, apierrors.IsAlreadyExists(err), apierrors.IsConflict(err):
+			return http.StatusConflict
+		case err == driver.ErrInvalidKey, apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+			return http.StatusBadRequest
+		case apierrors.IsForbidden(err):
+			return http.StatusForbidden
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return http.StatusInternalServerError
+}
+
+// failureStatus v1接口出错时也返回200，v2接口按错误类型返回状态码
+func failureStatus(c *gin.Context, err error) int {
+	if c.GetBool(ctxStatusCodesKey) {
+		return errorStatus(err)
+	}
+	return http.StatusOK
+}
+
 func respErr(c *gin.Context, err error) {
-	glog.Warningln(err)
+	respErrStatus(c, failureStatus(c, err), err, nil)
+}
+
+// respErrData 返回错误的同时带上数据，例如批量操作中每一项的结果
+func respErrData(c *gin.Context, err error, data interface{}) {
+	respErrStatus(c, failureStatus(c, err), err, data)
+}
+
+// respErrStatus 需要区分HTTP状态码的错误，例如release被占用时返回409
+func respErrStatus(c *gin.Context, status int, err error, data interface{}) {
+	msg := secrets.mask(err.Error())
+	logRequest(c, "warning", msg)
+	c.Set(ctxErrorKey, msg)
 
-	c.JSON(http.StatusOK, &respBody{
+	c.JSON(status, &respBody{
 		Code:  1,
-		Error: err.Error(),
+		Data:  data,
+		Error: msg,
 	})
 }
 
+// respOK 默认返回200，v2创建资源的接口返回201
 func respOK(c *gin.Context, data interface{}) {
-	c.JSON(http.StatusOK, &respBody{
+	status := http.StatusOK
+	if s := c.GetInt(ctxSuccessStatusKey); s != 0 {
+		status = s
+	}
+	c.JSON(status, &respBody{
 		Code: 0,
 		Data: data,
 	})
 }
 
+// newEngine 只信任trustedProxies中的代理设置的X-Forwarded-For，gin默认信任所有代理
+func newEngine(trustedProxies []string) (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
 func RegisterRouter(router *gin.Engine) {
+	// 所有api都经过限流，变更操作限制并发数
+	api := router.Group("/api", rateLimit(), concurrencyLimit())
+
 	// helm env
-	envs := router.Group("/api/envs")
+	envs := api.Group("/envs")
 	{
 		envs.GET("", getHelmEnvs)
 	}
 
 	// helm repo
-	repositories := router.Group("/api/repos")
+	repositories := api.Group("/repos")
 	{
 		// helm search repo
 		repositories.GET("/charts", listRepoCharts)
 		// helm repo list
 		repositories.GET("", listRepositories)
 		// helm repo add [name] [url] [flags]
-		repositories.POST("/add", addRepository)
+		repositories.POST("/add", audit("repo-add"), addRepository)
 		// helm repo remove [name1 name2]
-		repositories.DELETE("/remove/:reponame", removeRepository)
+		repositories.DELETE("/remove/:reponame", audit("repo-remove"), removeRepository)
 		// helm repo update
-		repositories.PUT("/update", updateRepositories)
+		repositories.PUT("/update", audit("repo-update"), updateRepositories)
+		// index refresh status
+		repositories.GET("/status", listRepositoryStatus)
+		// list all versions of a chart
+		repositories.GET("/:repo/charts/:chart/versions", listChartVersions)
+		// check connectivity of a repo before adding it
+		repositories.POST("/check", checkNewRepository)
+		// check connectivity of an added repo
+		repositories.POST("/:repo/check", checkRepositoryByName)
+		// edit an added repo
+		repositories.PUT("/:repo", audit("repo-edit"), editRepository)
 	}
 
 	// helm chart
-	charts := router.Group("/api/charts")
+	charts := api.Group("/charts")
 	{
 		// helm show all/readme/values/chart
 		charts.GET("", showChart)
@@ -63,33 +182,59 @@ func RegisterRouter(router *gin.Engine) {
 		// helm pull
 		charts.POST("/export", exportChart)
 		// create chart
-		charts.POST("/create", createChart)
+		charts.POST("/create", audit("chart-create"), createChart)
 		// update chart
-		charts.PUT("/update", updateChart)
+		charts.PUT("/update", audit("chart-update"), updateChart)
 		// upload chart
-		charts.POST("/upload", uploadChart)
+		charts.POST("/upload", audit("chart-upload"), uploadChart)
 		// list uploaded charts
 		charts.GET("/upload", listUploadedCharts)
 	}
 
+	// k8s namespace
+	namespaces := api.Group("/namespaces")
+	{
+		// list namespaces with release counts
+		namespaces.GET("", listNamespaces)
+		// create namespace with labels and quotas
+		namespaces.POST("", audit("namespace-create"), createNamespace)
+		// delete namespace without releases
+		namespaces.DELETE("/:namespace", audit("namespace-delete"), deleteNamespace)
+	}
+
 	// helm release
-	releases := router.Group("/api/namespaces/:namespace/releases")
+	releases := api.Group("/namespaces/:namespace/releases")
 	{
 		// helm list releases ->  helm list
 		releases.GET("", listReleases)
 		// helm get
 		releases.GET("/:release", showReleaseInfo)
 		// helm install
-		releases.POST("/:release", installRelease)
+		releases.POST("/:release", audit("install"), lockRelease("install"), installRelease)
 		// helm upgrade
-		releases.PUT("/:release", upgradeRelease)
+		releases.PUT("/:release", audit("upgrade"), lockRelease("upgrade"), upgradeRelease)
 		// helm uninstall
-		releases.DELETE("/:release", uninstallRelease)
+		releases.DELETE("/:release", audit("uninstall"), lockRelease("uninstall"), uninstallRelease)
 		// helm rollback
-		releases.PUT("/:release/versions/:reversion", rollbackRelease)
+		releases.PUT("/:release/versions/:reversion", audit("rollback"), lockRelease("rollback"), rollbackRelease)
+		// recover release stuck in pending states
+		releases.POST("/:release/recover", audit("recover"), lockRelease("recover"), recoverRelease)
 		// helm status
 		releases.GET("/:release/status", getReleaseStatus)
 		// helm history
 		releases.GET("/:release/histories", listReleaseHistories)
 	}
+
+	// effective config
+	api.GET("/config", getConfig)
+
+	// audit log
+	api.GET("/audit", listAuditEvents)
+
+	// release upgrade availability
+	api.GET("/namespaces/:namespace/upgrades", listReleaseUpgrades)
+	api.GET("/upgrades", listAllReleaseUpgrades)
+
+	// 资源化的v2接口，v1接口保持不变
+	registerRouterV2(router)
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// 静态路径和参数路径在同一层级(如/repos/status与/repos/:repo)需要gin >= v1.7.2，
+// 降级gin时注册路由会panic或请求被错误匹配
+func TestRegisterRouterStaticAndParamSiblings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	// 用只记录匹配结果的engine验证路由匹配，避免调用真实handler
+	matched := ""
+	probe := gin.New()
+	routes := map[string]bool{}
+	for _, r := range router.Routes() {
+		route := r.Path
+		routes[r.Method+" "+route] = true
+		probe.Handle(r.Method, route, func(*gin.Context) { matched = route })
+	}
+
+	tests := []struct {
+		method, path, route string
+	}{
+		{http.MethodGet, "/api/repos/charts", "/api/repos/charts"},
+		{http.MethodGet, "/api/repos/status", "/api/repos/status"},
+		{http.MethodGet, "/api/repos/stable/charts/mysql/versions", "/api/repos/:repo/charts/:chart/versions"},
+		{http.MethodPost, "/api/repos/check", "/api/repos/check"},
+		{http.MethodPost, "/api/repos/stable/check", "/api/repos/:repo/check"},
+		{http.MethodPut, "/api/repos/update", "/api/repos/update"},
+		{http.MethodPut, "/api/repos/stable", "/api/repos/:repo"},
+		{http.MethodGet, "/api/v2/repos/stable", "/api/v2/repos/:repo"},
+		{http.MethodPost, "/api/v2/repos/refresh", "/api/v2/repos/refresh"},
+		{http.MethodPut, "/api/v2/charts/stable/mysql/versions/1.0.0", "/api/v2/charts/:repo/:chart/versions/:version"},
+		{http.MethodGet, "/api/v2/charts/stable/mysql/versions/1.0.0/export", "/api/v2/charts/:repo/:chart/versions/:version/export"},
+		{http.MethodGet, "/api/v2/namespaces/default/releases/web/status", "/api/v2/namespaces/:namespace/releases/:release/status"},
+		{http.MethodGet, "/api/v2/namespaces/default/releases/web", "/api/v2/namespaces/:namespace/releases/:release"},
+	}
+	for _, tt := range tests {
+		if !routes[tt.method+" "+tt.route] {
+			t.Errorf("route %s %s is not registered", tt.method, tt.route)
+			continue
+		}
+		matched = ""
+		probe.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if matched != tt.route {
+			t.Errorf("%s %s matched %q, want %q", tt.method, tt.path, matched, tt.route)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{badRequestf("bad"), http.StatusBadRequest},
+		{errors.Wrap(notFoundf("missing"), "wrapped"), http.StatusNotFound},
+		{errors.Wrap(driver.ErrReleaseNotFound, "get"), http.StatusNotFound},
+		{driver.ErrReleaseExists, http.StatusConflict},
+		// helm install和upgrade返回的未导出错误
+		{errors.New("cannot re-use a name that is still in use"), http.StatusConflict},
+		{errors.New(`"web" has no deployed releases`), http.StatusNotFound},
+		{errors.New("unknown"), http.StatusInternalServerError},
+		{nil, http.StatusInternalServerError},
+
+		{conflictf("busy"), http.StatusConflict},
+		{forbiddenf("protected"), http.StatusForbidden},
+		{errors.Wrap(driver.ErrInvalidKey, "get"), http.StatusBadRequest},
+		{errors.Wrap(&os.PathError{Op: "open", Path: "chart.tgz", Err: os.ErrNotExist}, "load"), http.StatusNotFound},
+		// k8s的错误
+		{apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "team-a"), http.StatusNotFound},
+		{apierrors.NewAlreadyExists(schema.GroupResource{Resource: "namespaces"}, "team-a"), http.StatusConflict},
+		{errors.Wrap(apierrors.NewConflict(schema.GroupResource{Resource: "leases"}, "web", errors.New("changed")), "update"), http.StatusConflict},
+		{apierrors.NewInvalid(schema.GroupKind{Kind: "Namespace"}, "Team_A", nil), http.StatusBadRequest},
+		{apierrors.NewBadRequest("bad"), http.StatusBadRequest},
+		{errors.Wrap(apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", errors.New("rbac")), "list"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("errorStatus(%q) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// errorStatus按错误信息判断的helm错误，升级helm后信息变化时这里会失败
+func TestErrorStatusHelmMessages(t *testing.T) {
+	actionConfig := testActionConfig(t, "team-a")
+	ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "web", Version: "0.1.0"}}
+
+	install := action.NewInstall(actionConfig)
+	install.ReleaseName, install.Namespace = "web", "team-a"
+	if _, err := install.Run(ch, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := install.Run(ch, nil)
+	if got := errorStatus(err); got != http.StatusConflict {
+		t.Errorf("errorStatus(%v) = %d, want 409", err, got)
+	}
+
+	_, err = action.NewUpgrade(actionConfig).Run("missing", ch, nil)
+	if got := errorStatus(err); got != http.StatusNotFound {
+		t.Errorf("errorStatus(%v) = %d, want 404", err, got)
+	}
+}
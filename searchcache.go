@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"helm.sh/helm/v3/cmd/helm/search"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// searchIndexCache 缓存解析后的仓库index，只有index文件变化(仓库更新/添加/删除)时才重建
+type searchIndexCache struct {
+	mu      sync.RWMutex
+	entries map[bool]*cachedSearchIndex // key: 是否包含所有版本
+}
+
+type cachedSearchIndex struct {
+	index  *search.Index
+	files  map[string]*repo.IndexFile // 仓库名 -> 解析后的index
+	stamps map[string]time.Time       // 仓库名 -> index文件修改时间
+}
+
+var searchCache = &searchIndexCache{entries: map[bool]*cachedSearchIndex{}}
+
+// get 返回缓存的index，index文件有变化时重建
+func (s *searchIndexCache) get(all bool) *search.Index {
+	return s.load(all).index
+}
+
+// indexFile 返回缓存中解析后的仓库index
+func (s *searchIndexCache) indexFile(name string) (*repo.IndexFile, bool) {
+	ind, ok := s.load(true).files[name]
+	return ind, ok
+}
+
+func (s *searchIndexCache) load(all bool) *cachedSearchIndex {
+	stamps := currentIndexStamps()
+
+	s.mu.RLock()
+	cached := s.entries[all]
+	s.mu.RUnlock()
+	if cached != nil && sameStamps(cached.stamps, stamps) {
+		return cached
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 等待锁期间可能已经被其他请求重建
+	if cached := s.entries[all]; cached != nil && sameStamps(cached.stamps, stamps) {
+		return cached
+	}
+
+	i := search.NewIndex()
+	files := map[string]*repo.IndexFile{}
+	for _, re := range repositories.all() {
+		if _, ok := stamps[re.Name]; !ok {
+			continue
+		}
+		n := re.Name
+		f := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(n))
+		ind, err := repo.LoadIndexFile(f)
+		if err != nil {
+			glog.Warningf("WARNING: Repo %q is corrupt or missing. Try 'helm repo update'.", n)
+			continue
+		}
+
+		i.AddRepo(n, ind, all)
+		files[n] = ind
+	}
+	cached = &cachedSearchIndex{index: i, files: files, stamps: stamps}
+	s.entries[all] = cached
+	return cached
+}
+
+// invalidate 丢弃缓存，下次查询时重建
+func (s *searchIndexCache) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = map[bool]*cachedSearchIndex{}
+}
+
+// currentIndexStamps 所有非OCI仓库的index文件修改时间，缺失的index不计入
+func currentIndexStamps() map[string]time.Time {
+	stamps := map[string]time.Time{}
+	for _, re := range repositories.all() {
+		if isOCIReference(re.URL) {
+			continue
+		}
+		f := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile(re.Name))
+		st, err := os.Stat(f)
+		missingIndexes.report(re.Name, err != nil)
+		if err != nil {
+			continue
+		}
+		stamps[re.Name] = st.ModTime()
+	}
+	return stamps
+}
+
+// indexStates 每次搜索都会检查index，只在仓库的index缺失或恢复时输出一次日志
+type indexStates struct {
+	mu      sync.Mutex
+	missing map[string]bool
+}
+
+var missingIndexes = &indexStates{missing: map[string]bool{}}
+
+// report 返回状态是否变化
+func (s *indexStates) report(name string, missing bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.missing[name] == missing {
+		return false
+	}
+	if missing {
+		s.missing[name] = true
+		glog.Warningf("WARNING: Repo %q is corrupt or missing. Try 'helm repo update'.", name)
+	} else {
+		delete(s.missing, name)
+		glog.Infof("index of repo %q is available again", name)
+	}
+	return true
+}
+
+func sameStamps(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if bt, ok := b[name]; !ok || !bt.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// setupSearchRepos 把testdata/index.yaml作为多个仓库的index放到临时的仓库缓存目录
+func setupSearchRepos(tb testing.TB, names ...string) func() {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "index.yaml"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "helm-proxy-search")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	oldCache, oldRepos := settings.RepositoryCache, repositories.repos
+	settings.RepositoryCache = dir
+	repositories.repos = nil
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, helmpath.CacheIndexFile(name)), data, 0644); err != nil {
+			tb.Fatal(err)
+		}
+		repositories.repos = append(repositories.repos, &repoConfig{Entry: repo.Entry{Name: name, URL: "https://charts.example.com/" + name}})
+	}
+	searchCache.invalidate()
+
+	return func() {
+		settings.RepositoryCache, repositories.repos = oldCache, oldRepos
+		searchCache.invalidate()
+		os.RemoveAll(dir)
+	}
+}
+
+func searchCharts(tb testing.TB, query string) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/repos/charts?"+query, nil)
+	listRepoCharts(c)
+	if w.Code != http.StatusOK {
+		tb.Fatalf("search %q: %d %s", query, w.Code, w.Body.String())
+	}
+}
+
+func TestSearchCacheRebuildsOnIndexChange(t *testing.T) {
+	defer setupSearchRepos(t, "stable", "incubator")()
+
+	first := searchCache.get(false)
+	if searchCache.get(false) != first {
+		t.Fatal("index is rebuilt although index files did not change")
+	}
+	if _, ok := searchCache.indexFile("stable"); !ok {
+		t.Fatal("parsed index of stable is not cached")
+	}
+
+	f := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile("stable"))
+	st, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f, st.ModTime().Add(1e9), st.ModTime().Add(1e9)); err != nil {
+		t.Fatal(err)
+	}
+	if searchCache.get(false) == first {
+		t.Fatal("index is not rebuilt after an index file changed")
+	}
+}
+
+// BenchmarkListRepoCharts 对比每次请求都重新解析index(cold)与使用缓存(cached)的搜索耗时
+func BenchmarkListRepoCharts(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	defer setupSearchRepos(b, "stable", "incubator", "experimental", "bitnami")()
+	const query = "keyword=database&versions=true"
+
+	b.Run("cold", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			searchCache.invalidate()
+			searchCharts(b, query)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		searchCharts(b, query)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			searchCharts(b, query)
+		}
+	})
+}
+
+func TestChartDownloadURL(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	repositories.repos = append(repositories.repos, &repoConfig{Entry: repo.Entry{Name: "oci", URL: "oci://registry.example.com/charts/"}})
+
+	tests := []struct {
+		repo, chart, version, want string
+		status                     int
+	}{
+		{repo: "stable", chart: "chart-00", version: "1.0.3", want: "https://charts.example.com/stable/charts/chart-00-1.0.3.tgz"},
+		{repo: "oci", chart: "demo", version: "1.0.0+build", want: "oci://registry.example.com/charts/demo:1.0.0_build"},
+		{repo: "stable", chart: "chart-00", version: "9.9.9", status: http.StatusNotFound},
+		{repo: "missing", chart: "chart-00", version: "1.0.3", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		got, err := chartDownloadURL(tt.repo, tt.chart, tt.version)
+		if tt.status != 0 {
+			if err == nil || errorStatus(err) != tt.status {
+				t.Errorf("chartDownloadURL(%s, %s, %s) = %q, %v, want status %d", tt.repo, tt.chart, tt.version, got, err, tt.status)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("chartDownloadURL(%s, %s, %s) = %q, %v, want %q", tt.repo, tt.chart, tt.version, got, err, tt.want)
+		}
+	}
+}
+
+func TestMissingIndexIsReportedOnce(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	defer delete(missingIndexes.missing, "stable")
+	f := filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile("stable"))
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if missingIndexes.report("stable", false) {
+		t.Error("an available index is reported")
+	}
+	os.Remove(f)
+	if !missingIndexes.report("stable", true) {
+		t.Error("a missing index is not reported")
+	}
+	for i := 0; i < 3; i++ {
+		currentIndexStamps()
+	}
+	if missingIndexes.report("stable", true) {
+		t.Error("a missing index is reported again")
+	}
+	ioutil.WriteFile(f, data, 0644)
+	currentIndexStamps()
+	if missingIndexes.missing["stable"] {
+		t.Error("a restored index is still recorded as missing")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const defaultCertReloadInterval = 10 * time.Second
+
+// HTTPS配置，配置clientCAFile后校验客户端证书
+type tlsConfig struct {
+	CertFile          string            `yaml:"certFile" json:"certFile"`
+	KeyFile           string            `yaml:"keyFile" json:"keyFile"`
+	ClientCAFile      string            `yaml:"clientCAFile" json:"clientCAFile"`           //校验客户端证书的CA
+	RequireClientCert bool              `yaml:"requireClientCert" json:"requireClientCert"` //false时客户端可不带证书
+	Identities        map[string]string `yaml:"identities" json:"identities"`               //客户端证书CN到用户的映射，未配置的使用CN
+	ReloadInterval    duration          `yaml:"reloadInterval" json:"reloadInterval"`       //检查证书文件变化的间隔
+}
+
+func (tc *tlsConfig) reloadInterval() time.Duration {
+	if tc.ReloadInterval > 0 {
+		return time.Duration(tc.ReloadInterval)
+	}
+	return defaultCertReloadInterval
+}
+
+// certReloader 握手时按间隔检查证书文件的修改时间，变化后重新加载
+type certReloader struct {
+	conf *tlsConfig
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime map[string]time.Time
+	current *tls.Config
+}
+
+// certs 开启HTTPS时的证书，配置重新加载时更新
+var certs *certReloader
+
+func newCertReloader(tc *tlsConfig) (*certReloader, error) {
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return nil, errors.New("tls certFile and keyFile are required")
+	}
+	r := &certReloader{conf: tc}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.conf.CertFile, r.conf.KeyFile}
+	if r.conf.ClientCAFile != "" {
+		files = append(files, r.conf.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	modTime := map[string]time.Time{}
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTime[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load tls certificate")
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.conf.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(r.conf.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.Errorf("no certificate found in %s", r.conf.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.conf.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r.modTime = modTime
+	r.current = config
+	return nil
+}
+
+func (r *certReloader) changed() bool {
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(r.modTime[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// config 返回当前配置，加载失败时继续使用旧证书
+func (r *certReloader) config() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= r.conf.reloadInterval() {
+		r.checked = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				glog.Errorf("failed to reload tls certificates, keep using the old ones: %v", err)
+			} else {
+				glog.Infoln("tls certificates reloaded")
+			}
+		}
+	}
+	return r.current
+}
+
+// update 配置重新加载后使用新的证书路径，调用前已校验过能加载
+func (r *certReloader) update(tc *tlsConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conf = tc
+	if err := r.load(); err != nil {
+		glog.Errorf("failed to load tls certificates, keep using the old ones: %v", err)
+	}
+	r.checked = time.Now()
+}
+
+// tlsConfig 给http.Server使用，每次握手取当前的证书和CA
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.config().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	}
+}
+
+// tlsIdentity 客户端证书校验通过后，以证书CN(或identities中的映射)作为请求用户
+func tlsIdentity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			cn := c.Request.TLS.VerifiedChains[0][0].Subject.CommonName
+			user := cn
+			if tc := currentConfig().TLS; tc != nil {
+				if mapped, ok := tc.Identities[cn]; ok {
+					user = mapped
+				}
+			}
+			if user != "" {
+				c.Set(ctxUserKey, user)
+			}
+		}
+		c.Next()
+	}
+}
+
+// listenUnix 监听unix socket，启动前删除残留的socket文件
+// socket上是明文HTTP，不经过客户端证书校验，只靠文件权限限制访问
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
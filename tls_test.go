@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "helm-proxy test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书，返回PEM格式的证书和私钥
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerCerts 写入服务端证书、私钥和校验客户端证书的CA
+func writeServerCerts(t *testing.T, dir string, ca *testCA, serial int64) *tlsConfig {
+	cert, key := ca.issue(t, "helm-proxy", serial, x509.ExtKeyUsageServerAuth)
+	tc := &tlsConfig{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	for file, b := range map[string][]byte{tc.CertFile: cert, tc.KeyFile: key, tc.ClientCAFile: ca.pem} {
+		if err := ioutil.WriteFile(file, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tc
+}
+
+func TestTLSIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	tc := writeServerCerts(t, dir, ca, 2)
+	tc.Identities = map[string]string{"alice": "alice-admin"}
+	old := helmConfig
+	defer func() { helmConfig = old }()
+	helmConfig = &HelmConfig{TLS: tc}
+
+	reloader, err := newCertReloader(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tlsIdentity())
+	router.GET("/whoami", func(c *gin.Context) { c.String(http.StatusOK, requestUser(c)) })
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = reloader.tlsConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	whoami := func(cn string) (string, error) {
+		conf := &tls.Config{RootCAs: pool}
+		if cn != "" {
+			certPEM, keyPEM := ca.issue(t, cn, time.Now().UnixNano(), x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+		resp, err := client.Get(server.URL + "/whoami")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	tests := []struct{ cn, want string }{
+		{"alice", "alice-admin"},
+		{"bob", "bob"},
+		{"", "anonymous@127.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got, err := whoami(tt.cn); err != nil || got != tt.want {
+			t.Errorf("client cert %q: got %q, %v, want %q", tt.cn, got, err, tt.want)
+		}
+	}
+
+	// 其他CA签发的客户端证书不被接受
+	other := newTestCA(t)
+	certPEM, keyPEM := other.issue(t, "alice", 3, x509.ExtKeyUsageClientAuth)
+	cert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}}}
+	if resp, err := client.Get(server.URL + "/whoami"); err == nil {
+		resp.Body.Close()
+		t.Error("client certificate of an unknown ca is accepted")
+	}
+
+	// 必须带客户端证书
+	tc.RequireClientCert = true
+	reloader.update(tc)
+	if _, err := whoami(""); err == nil {
+		t.Error("request without a client certificate is accepted although requireClientCert is set")
+	}
+	if got, err := whoami("bob"); err != nil || got != "bob" {
+		t.Errorf("client cert bob: got %q, %v", got, err)
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-proxy-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	tc := writeServerCerts(t, dir, ca, 10)
+	tc.ReloadInterval = duration(time.Nanosecond)
+
+	reloader, err := newCertReloader(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		leaf, err := x509.ParseCertificate(reloader.config().Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	touch := func(files ...string) {
+		later := time.Now().Add(time.Minute)
+		for _, f := range files {
+			if err := os.Chtimes(f, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := serial(); got != 10 {
+		t.Fatalf("serial %d, want 10", got)
+	}
+
+	// 证书文件更新后重新加载
+	writeServerCerts(t, dir, ca, 11)
+	touch(tc.CertFile, tc.KeyFile)
+	if got := serial(); got != 11 {
+		t.Errorf("serial %d after the certificate changed, want 11", got)
+	}
+
+	// 新证书无法加载时继续使用旧证书
+	if err := ioutil.WriteFile(tc.KeyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(tc.KeyFile)
+	if got := serial(); got != 11 {
+		t.Errorf("serial %d after a broken key, want the old certificate 11", got)
+	}
+
+	if _, err := newCertReloader(&tlsConfig{CertFile: tc.CertFile, KeyFile: tc.KeyFile}); err == nil {
+		t.Error("a broken key pair is accepted")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type releaseUpgradeElement struct {
+	releaseElement
+
+	// 来源仓库中的最新兼容版本，无法确定来源仓库时为空
+	Repo              string `json:"repo,omitempty"`
+	LatestVersion     string `json:"latest_version,omitempty"`
+	LatestAppVersion  string `json:"latest_app_version,omitempty"`
+	UpgradeAvailable  bool   `json:"upgrade_available"`
+	AppVersionChanged bool   `json:"app_version_changed"`
+	MajorBump         bool   `json:"major_bump"`
+
+	// 各仓库中同名chart的最新兼容版本
+	Candidates []upgradeCandidate `json:"candidates"`
+}
+
+type upgradeCandidate struct {
+	Repo             string `json:"repo"`
+	Origin           bool   `json:"origin"` // index中有release当前的chart版本
+	LatestVersion    string `json:"latest_version"`
+	LatestAppVersion string `json:"latest_app_version"`
+}
+
+// @Summary			查看release可升级版本
+// @Description 	将命名空间下所有release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级
+// @Tags			Release
+// @Param 			namespace path string true "release所在k8s的命名空间"
+// @Param 			repo query string false "只在指定仓库中查找，repo1,repo2..."
+// @Param 			devel query bool false "是否包含预发布版本"
+// @Success 		200 {object} respBody
+// @Router 			/namespaces/{namespace}/upgrades [get]
+func listReleaseUpgrades(c *gin.Context) {
+	reportReleaseUpgrades(c, c.Param("namespace"))
+}
+
+// @Summary			查看所有命名空间release可升级版本
+// @Description 	将所有命名空间下release的chart与仓库index比较，列出每个仓库中最新的兼容版本，并按release来源仓库(index中有当前chart版本的仓库)给出appVersion变化以及是否为大版本升级；没有集群范围的权限时逐个查询有权限的命名空间
+// @Tags			Release
+// @Param 			repo query string false "只在指定仓库中查找，repo1,repo2..."
+// @Param 			devel query bool false "是否包含预发布版本"
+// @Success 		200 {object} respBody
+// @Router 			/upgrades [get]
+func listAllReleaseUpgrades(c *gin.Context) {
+	reportReleaseUpgrades(c, "")
+}
+
+// reportReleaseUpgrades namespace为空时查询所有命名空间
+func reportReleaseUpgrades(c *gin.Context, namespace string) {
+	devel, _ := strconv.ParseBool(c.Query("devel"))
+	var repoNames []string
+	if repos := c.Query("repo"); repos != "" {
+		for _, name := range strings.Split(repos, ",") {
+			if !repositories.has(name) {
+				respErr(c, notFoundf("repo %s not found", name))
+				return
+			}
+			repoNames = append(repoNames, name)
+		}
+	} else {
+		for _, e := range repositories.all() {
+			repoNames = append(repoNames, e.Name)
+		}
+	}
+
+	actionConfig, err := actionConfigInit(c, namespace)
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+	results, err := deployedReleases(actionConfig, namespace)
+	if namespace == "" && apierrors.IsForbidden(errors.Cause(err)) {
+		glog.Warningf("no permission to list releases in all namespaces, listing permitted namespaces instead: %v", err)
+		var clientset kubernetes.Interface
+		if clientset, err = kubeClientset(""); err == nil {
+			results, err = permittedDeployedReleases(clientset, func(ns string) ([]*release.Release, error) {
+				actionConfig, err := actionConfigInit(c, ns)
+				if err != nil {
+					return nil, err
+				}
+				return deployedReleases(actionConfig, ns)
+			})
+		}
+	}
+	if err != nil {
+		respErr(c, err)
+		return
+	}
+
+	kubeVersion := ""
+	if dc, err := actionConfig.RESTClientGetter.ToDiscoveryClient(); err == nil {
+		if v, err := dc.ServerVersion(); err == nil {
+			kubeVersion = v.GitVersion
+		}
+	}
+	if kubeVersion == "" {
+		glog.Warningln("unable to get kubernetes version, chart kubeVersion constraints are ignored")
+	}
+
+	respOK(c, releaseUpgrades(results, repoNames, devel, kubeVersion))
+}
+
+// deployedReleases 列出已部署的release，namespace为空时列出所有命名空间
+func deployedReleases(actionConfig *action.Configuration, namespace string) ([]*release.Release, error) {
+	client := action.NewList(actionConfig)
+	client.AllNamespaces = namespace == ""
+	// 只比较已部署的release，All会覆盖StateMask，不能同时设置
+	client.StateMask = action.ListDeployed
+	return client.Run()
+}
+
+// permittedDeployedReleases 逐个命名空间列出已部署的release，跳过没有权限(403)的命名空间
+func permittedDeployedReleases(clientset kubernetes.Interface, list func(namespace string) ([]*release.Release, error)) ([]*release.Release, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var results []*release.Release
+	for _, ns := range namespaces.Items {
+		rels, err := list(ns.Name)
+		if apierrors.IsForbidden(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rels...)
+	}
+	return results, nil
+}
+
+// releaseUpgrades 在repoNames的index中查找每个release的可升级版本。
+// release没有记录chart来自哪个仓库，index中有当前chart版本的仓库视为来源仓库，
+// 只按来源仓库给出升级建议，避免把其他仓库中的同名chart当作升级版本
+func releaseUpgrades(results []*release.Release, repoNames []string, devel bool, kubeVersion string) []releaseUpgradeElement {
+	elements := make([]releaseUpgradeElement, 0, len(results))
+	for _, r := range results {
+		if r.Chart == nil || r.Chart.Metadata == nil {
+			continue
+		}
+		e := releaseUpgradeElement{releaseElement: constructReleaseElement(r, false), Candidates: []upgradeCandidate{}}
+		current, err := semver.NewVersion(e.ChartVersion)
+		if err != nil {
+			elements = append(elements, e)
+			continue
+		}
+
+		var latest *semver.Version
+		for _, name := range repoNames {
+			ind, ok := searchCache.indexFile(name)
+			if !ok {
+				continue
+			}
+			versions := ind.Entries[e.Chart]
+			cv := latestCompatibleVersion(versions, current, devel || current.Prerelease() != "", kubeVersion)
+			if cv == nil {
+				continue
+			}
+			candidate := upgradeCandidate{
+				Repo:             name,
+				Origin:           hasChartVersion(versions, current),
+				LatestVersion:    cv.Version,
+				LatestAppVersion: cv.AppVersion,
+			}
+			e.Candidates = append(e.Candidates, candidate)
+
+			v, _ := semver.NewVersion(cv.Version)
+			if candidate.Origin && (latest == nil || v.GreaterThan(latest)) {
+				latest = v
+				e.Repo = name
+				e.LatestVersion = cv.Version
+				e.LatestAppVersion = cv.AppVersion
+			}
+		}
+
+		if latest != nil {
+			e.UpgradeAvailable = latest.GreaterThan(current)
+			e.AppVersionChanged = e.LatestAppVersion != e.AppVersion
+			e.MajorBump = latest.Major() > current.Major()
+		}
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+func hasChartVersion(versions repo.ChartVersions, current *semver.Version) bool {
+	for _, cv := range versions {
+		if v, err := semver.NewVersion(cv.Version); err == nil && v.Equal(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// latestCompatibleVersion 不低于当前版本的最新可用版本，跳过废弃版本、预发布版本(devel除外)和kubeVersion不兼容的版本
+func latestCompatibleVersion(versions repo.ChartVersions, current *semver.Version, devel bool, kubeVersion string) *repo.ChartVersion {
+	var latest *repo.ChartVersion
+	var latestV *semver.Version
+	for _, cv := range versions {
+		v, err := semver.NewVersion(cv.Version)
+		if err != nil || v.LessThan(current) {
+			continue
+		}
+		if cv.Deprecated || (!devel && v.Prerelease() != "") {
+			continue
+		}
+		if kubeVersion != "" && cv.KubeVersion != "" && !chartutil.IsCompatibleRange(cv.KubeVersion, kubeVersion) {
+			continue
+		}
+		if latestV == nil || v.GreaterThan(latestV) {
+			latest, latestV = cv, v
+		}
+	}
+	return latest
+}
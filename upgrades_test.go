@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// 另一个仓库中有同名但无关的chart，版本更高
+const mirrorIndex = `apiVersion: v1
+entries:
+  chart-00:
+  - apiVersion: v2
+    appVersion: 9.0.0
+    name: chart-00
+    urls:
+    - charts/chart-00-9.0.0.tgz
+    version: 9.0.0
+`
+
+func deployedRelease(name, chartName, version, appVersion string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: chartName, Version: version, AppVersion: appVersion}},
+	}
+}
+
+func TestReleaseUpgradesPreferOriginRepo(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	if err := ioutil.WriteFile(filepath.Join(settings.RepositoryCache, helmpath.CacheIndexFile("mirror")), []byte(mirrorIndex), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repositories.repos = append(repositories.repos, &repoConfig{Entry: repo.Entry{Name: "mirror", URL: "https://mirror.example.com/"}})
+	searchCache.invalidate()
+
+	results := []*release.Release{
+		deployedRelease("web", "chart-00", "1.0.1", "2.1.0"),
+		deployedRelease("orphan", "chart-00", "0.9.0", "1.0.0"),
+	}
+
+	elements := releaseUpgrades(results, []string{"stable", "mirror"}, false, "")
+	if len(elements) != 2 {
+		t.Fatalf("got %d elements, want 2", len(elements))
+	}
+
+	web := elements[0]
+	if web.Repo != "stable" || web.LatestVersion != "1.0.3" || !web.UpgradeAvailable || web.MajorBump {
+		t.Errorf("web: upgrade %s/%s available %v major %v, want stable/1.0.3 without major bump", web.Repo, web.LatestVersion, web.UpgradeAvailable, web.MajorBump)
+	}
+	want := []upgradeCandidate{
+		{Repo: "stable", Origin: true, LatestVersion: "1.0.3", LatestAppVersion: "2.3.0"},
+		{Repo: "mirror", Origin: false, LatestVersion: "9.0.0", LatestAppVersion: "9.0.0"},
+	}
+	if len(web.Candidates) != len(want) {
+		t.Fatalf("web: candidates %+v, want %+v", web.Candidates, want)
+	}
+	for i := range want {
+		if web.Candidates[i] != want[i] {
+			t.Errorf("web: candidate %d is %+v, want %+v", i, web.Candidates[i], want[i])
+		}
+	}
+
+	// 没有仓库有当前版本，无法确定来源仓库，只列出候选版本
+	orphan := elements[1]
+	if orphan.Repo != "" || orphan.LatestVersion != "" || orphan.UpgradeAvailable || len(orphan.Candidates) != 2 {
+		t.Errorf("orphan: got %+v, want only candidates", orphan)
+	}
+
+	// repo参数缩小查找范围
+	elements = releaseUpgrades(results[:1], []string{"mirror"}, false, "")
+	if e := elements[0]; e.Repo != "" || e.UpgradeAvailable || len(e.Candidates) != 1 || e.Candidates[0].Origin {
+		t.Errorf("mirror only: got %+v, want a single non-origin candidate", e)
+	}
+}
+
+func TestReleaseUpgradesUnknownRepo(t *testing.T) {
+	defer setupSearchRepos(t, "stable")()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRouter(router)
+
+	for _, path := range []string{"/api/v2/upgrades?repo=stable,nope", "/api/v2/namespaces/default/upgrades?repo=nope"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d %s, want %d", path, w.Code, w.Body.String(), http.StatusNotFound)
+		}
+	}
+}
+
+func TestPermittedDeployedReleases(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	)
+	list := func(ns string) ([]*release.Release, error) {
+		if ns == "kube-system" {
+			return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", nil)
+		}
+		return []*release.Release{deployedRelease("web-"+ns, "chart-00", "1.0.1", "2.1.0")}, nil
+	}
+
+	results, err := permittedDeployedReleases(clientset, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "web-team-a" || results[1].Name != "web-team-b" {
+		t.Errorf("got %d releases, want the releases of team-a and team-b", len(results))
+	}
+
+	// 其他错误不能被忽略
+	_, err = permittedDeployedReleases(clientset, func(string) ([]*release.Release, error) {
+		return nil, apierrors.NewInternalError(errors.New("etcd unavailable"))
+	})
+	if err == nil {
+		t.Error("an internal error is ignored")
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -13,18 +12,25 @@ import (
 func uploadChart(c *gin.Context) {
 	file, header, err := c.Request.FormFile("chart")
 	if err != nil {
-		respErr(c, err)
+		respErr(c, badRequestf("%v", err))
 		return
 	}
 
 	filename := header.Filename
+	setAuditChart(c, filename, "")
 	t := strings.Split(filename, ".")
-	if t[len(t)-1] != "tgz" {
-		respErr(c, fmt.Errorf("chart file suffix must .tgz"))
+	// 需要校验签名时，.prov文件与chart一起上传
+	if t[len(t)-1] != "tgz" && !strings.HasSuffix(filename, ".tgz.prov") {
+		respErr(c, badRequestf("chart file suffix must .tgz or .tgz.prov"))
 		return
 	}
+	if t[len(t)-1] == "prov" {
+		uploadSizeBytes.WithLabelValues("prov").Observe(float64(header.Size))
+	} else {
+		uploadSizeBytes.WithLabelValues("chart").Observe(float64(header.Size))
+	}
 
-	out, err := os.Create(helmConfig.UploadPath + "/" + filename)
+	out, err := os.Create(currentConfig().UploadPath + "/" + filename)
 	if err != nil {
 		respErr(c, err)
 		return
@@ -42,7 +48,7 @@ func uploadChart(c *gin.Context) {
 
 func listUploadedCharts(c *gin.Context) {
 	charts := []string{}
-	files, err := ioutil.ReadDir(helmConfig.UploadPath)
+	files, err := ioutil.ReadDir(currentConfig().UploadPath)
 	if err != nil {
 		respErr(c, err)
 		return